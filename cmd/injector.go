@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -8,8 +9,12 @@ import (
 
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/deadletter"
+	"github.com/inloco/kafka-elasticsearch-injector/src/elasticsearch"
 	"github.com/inloco/kafka-elasticsearch-injector/src/injector"
 	"github.com/inloco/kafka-elasticsearch-injector/src/kafka"
 	"github.com/inloco/kafka-elasticsearch-injector/src/logger_builder"
@@ -21,16 +26,6 @@ import (
 func main() {
 	logger := logger_builder.NewLogger("kafka-elasticsearch-injector")
 
-	probesPort := os.Getenv("PROBES_PORT")
-	p := probes.New(probesPort)
-	p.SetLivenessCheck(func() bool {
-		return true
-	})
-	level.Info(logger).Log(
-		"message", fmt.Sprintf("Initializing kubernetes probes at %s", probesPort),
-	)
-	go p.Serve()
-	metrics.Register()
 	schemaRegistry, err := schema_registry.NewSchemaRegistry(os.Getenv("SCHEMA_REGISTRY_URL"))
 	if err != nil {
 		level.Error(logger).Log("err", err, "message", "failed to create schema registry client")
@@ -38,27 +33,115 @@ func main() {
 
 	kafkaConfig := &kafka.Config{
 		Type:                  kafka.ConsumerType,
+		Version:               os.Getenv("KAFKA_VERSION"),
+		ClientID:              os.Getenv("KAFKA_CLIENT_ID"),
+		InitialOffset:         os.Getenv("KAFKA_CONSUMER_INITIAL_OFFSET"),
+		FetchMinBytes:         os.Getenv("KAFKA_CONSUMER_FETCH_MIN_BYTES"),
+		FetchMaxBytes:         os.Getenv("KAFKA_CONSUMER_FETCH_MAX_BYTES"),
+		SessionTimeout:        os.Getenv("KAFKA_CONSUMER_SESSION_TIMEOUT"),
+		HeartbeatInterval:     os.Getenv("KAFKA_CONSUMER_HEARTBEAT_INTERVAL"),
+		IsolationLevel:        os.Getenv("KAFKA_CONSUMER_ISOLATION_LEVEL"),
 		Topics:                strings.Split(os.Getenv("KAFKA_TOPICS"), ","),
+		TopicsPattern:         os.Getenv("KAFKA_TOPICS_PATTERN"),
+		TopicsRefreshInterval: os.Getenv("KAFKA_TOPICS_REFRESH_INTERVAL"),
 		ConsumerGroup:         os.Getenv("KAFKA_CONSUMER_GROUP"),
 		Concurrency:           os.Getenv("KAFKA_CONSUMER_CONCURRENCY"),
 		BatchSize:             os.Getenv("KAFKA_CONSUMER_BATCH_SIZE"),
 		BufferSize:            os.Getenv("KAFKA_CONSUMER_BUFFER_SIZE"),
 		MetricsUpdateInterval: os.Getenv("KAFKA_CONSUMER_METRICS_UPDATE_INTERVAL"),
 		RecordType:            os.Getenv("KAFKA_CONSUMER_RECORD_TYPE"),
+		CDCMode:               os.Getenv("KAFKA_CONSUMER_CDC_MODE"),
+		DeleteOnTombstone:     os.Getenv("KAFKA_CONSUMER_DELETE_ON_TOMBSTONE"),
+		ValidateSchema:        os.Getenv("KAFKA_CONSUMER_VALIDATE_SCHEMA"),
+		DecimalFormat:         os.Getenv("KAFKA_CONSUMER_AVRO_DECIMAL_FORMAT"),
+		TimestampFormat:       os.Getenv("KAFKA_CONSUMER_AVRO_TIMESTAMP_FORMAT"),
 		IncludeKey:            os.Getenv("KAFKA_CONSUMER_INCLUDE_KEY"),
+		KeyFormat:             os.Getenv("KAFKA_CONSUMER_KEY_FORMAT"),
+		IncludeMetadata:       os.Getenv("KAFKA_CONSUMER_INCLUDE_METADATA"),
+		MetadataField:         os.Getenv("KAFKA_CONSUMER_METADATA_FIELD"),
+		MetadataHeaders:       strings.Split(os.Getenv("KAFKA_CONSUMER_METADATA_HEADERS"), ","),
+		Filter:                os.Getenv("KAFKA_CONSUMER_FILTER"),
+		FlushInterval:         os.Getenv("KAFKA_CONSUMER_FLUSH_INTERVAL"),
+		ShutdownTimeout:       os.Getenv("KAFKA_CONSUMER_SHUTDOWN_TIMEOUT"),
+		SASLMechanism:         os.Getenv("KAFKA_SASL_MECHANISM"),
+		SASLUsername:          os.Getenv("KAFKA_SASL_USERNAME"),
+		SASLPassword:          os.Getenv("KAFKA_SASL_PASSWORD"),
+		TLSEnabled:            os.Getenv("KAFKA_TLS_ENABLED"),
+		TLSCAFile:             os.Getenv("KAFKA_TLS_CA_FILE"),
+		TLSCertFile:           os.Getenv("KAFKA_TLS_CERT_FILE"),
+		TLSKeyFile:            os.Getenv("KAFKA_TLS_KEY_FILE"),
+		TLSInsecureSkipVerify: os.Getenv("KAFKA_TLS_INSECURE_SKIP_VERIFY"),
+	}
+	esConfig := elasticsearch.NewConfig()
+	if len(os.Args) > 1 && os.Args[1] == "print-template" {
+		if schemaRegistry == nil {
+			level.Error(logger).Log("message", "schema registry unavailable, index templates can't be generated")
+			os.Exit(1)
+		}
+		printIndexTemplates(logger, schemaRegistry, kafkaConfig, esConfig)
+		return
 	}
+	kafkaConfig.Brokers, err = kafka.ParseBrokers(os.Getenv("KAFKA_ADDRESS"))
+	if err != nil {
+		level.Error(logger).Log("err", err, "message", "invalid kafka address")
+		panic(err)
+	}
+
+	probesPort := os.Getenv("PROBES_PORT")
+	p := probes.New(probesPort)
+	p.SetLivenessCheck(func() bool {
+		return true
+	})
+	level.Info(logger).Log(
+		"message", fmt.Sprintf("Initializing kubernetes probes at %s", probesPort),
+	)
+	go p.Serve()
+	metrics.Register()
 	metricsPublisher := metrics.NewMetricsPublisher()
-	service := injector.NewService(logger, metricsPublisher)
+
+	var deadLetter deadletter.Publisher
+	if dlqTopic := os.Getenv("KAFKA_DLQ_TOPIC"); dlqTopic != "" {
+		dlqConfig, err := kafka.NewSaramaConfig(kafkaConfig)
+		if err != nil {
+			level.Error(logger).Log("err", err, "message", "invalid kafka configuration")
+			panic(err)
+		}
+		deadLetter, err = deadletter.NewPublisher(kafkaConfig.Brokers, dlqTopic, dlqConfig, metricsPublisher)
+		if err != nil {
+			level.Error(logger).Log("err", err, "message", "error creating dead letter publisher")
+			panic(err)
+		}
+		defer deadLetter.Close()
+	}
+
+	if esConfig.TemplateFromSchema {
+		templates, err := injector.IndexTemplates(schemaRegistry, kafkaConfig, esConfig)
+		if err == nil {
+			db := elasticsearch.NewDatabase(logger, esConfig, metricsPublisher)
+			err = injector.BootstrapIndexTemplates(logger, db, templates)
+		}
+		if err != nil {
+			level.Error(logger).Log("err", err, "message", "error creating index templates")
+			panic(err)
+		}
+	}
+
+	service := injector.NewService(logger, metricsPublisher, deadLetter)
 	p.SetReadinessCheck(service.ReadinessCheck)
 
 	endpoints := injector.MakeEndpoints(service)
 
-	consumer, err := injector.MakeKafkaConsumer(endpoints, logger, schemaRegistry, kafkaConfig)
+	consumer, err := injector.MakeKafkaConsumer(endpoints, logger, schemaRegistry, kafkaConfig, deadLetter)
 	if err != nil {
 		level.Error(logger).Log("err", err, "message", "error creating kafka consumer")
 		panic(err)
 	}
-	k := kafka.NewKafka(os.Getenv("KAFKA_ADDRESS"), consumer, metricsPublisher)
+	saramaConfig, err := kafka.NewSaramaConfig(kafkaConfig)
+	if err != nil {
+		level.Error(logger).Log("err", err, "message", "invalid kafka configuration")
+		panic(err)
+	}
+	k := kafka.NewKafka(kafkaConfig.Brokers, saramaConfig, consumer, metricsPublisher)
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
@@ -74,5 +157,74 @@ func main() {
 			}
 		}
 	}()
-	k.Start(signals, notifications)
+	replay := len(os.Args) > 1 && os.Args[1] == "replay"
+	if replay {
+		from, until, endOffsets := replayInterval(logger)
+		err = k.Replay(from, until, endOffsets, signals, notifications)
+	} else {
+		err = k.Start(signals, notifications)
+	}
+	if err == kafka.ErrShutdownTimeout {
+		// workers are still sending records, closing the Elasticsearch client
+		// under them would fail their batches. The dead letter producer is
+		// closed anyway, os.Exit skips the deferred Close, so the messages it
+		// already accepted are flushed.
+		level.Error(logger).Log("err", err, "message", "kafka consumer stopped without flushing buffered messages")
+		if deadLetter != nil {
+			if err := deadLetter.Close(); err != nil {
+				level.Error(logger).Log("err", err, "message", "error closing dead letter publisher")
+			}
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		message := "kafka consumer failed"
+		if replay {
+			message = "replay failed"
+		}
+		level.Error(logger).Log("err", err, "message", message)
+	}
+	service.Close()
+	level.Info(logger).Log("message", "kafka consumer stopped")
+}
+
+// printIndexTemplates writes the index templates generated from the topic
+// schemas to stdout, keyed by template name, for review.
+func printIndexTemplates(logger log.Logger, schemaRegistry *schema_registry.SchemaRegistry, kafkaConfig *kafka.Config, esConfig elasticsearch.Config) {
+	templates, err := injector.IndexTemplates(schemaRegistry, kafkaConfig, esConfig)
+	if err != nil {
+		level.Error(logger).Log("err", err, "message", "error generating index templates")
+		os.Exit(1)
+	}
+	out, err := json.MarshalIndent(templates, "", "  ")
+	if err != nil {
+		level.Error(logger).Log("err", err, "message", "error encoding index templates")
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+}
+
+// replayInterval reads the REPLAY_FROM and REPLAY_UNTIL RFC 3339 timestamps,
+// and the REPLAY_END_OFFSETS of partitions. The end is zero when REPLAY_UNTIL
+// is not set.
+func replayInterval(logger log.Logger) (time.Time, time.Time, map[string]map[int32]int64) {
+	from, err := time.Parse(time.RFC3339, os.Getenv("REPLAY_FROM"))
+	if err != nil {
+		level.Error(logger).Log("err", err, "message", "invalid replay start time")
+		panic(err)
+	}
+	var until time.Time
+	if u := os.Getenv("REPLAY_UNTIL"); u != "" {
+		until, err = time.Parse(time.RFC3339, u)
+		if err != nil {
+			level.Error(logger).Log("err", err, "message", "invalid replay end time")
+			panic(err)
+		}
+	}
+	endOffsets, err := kafka.ParseReplayEndOffsets(os.Getenv("REPLAY_END_OFFSETS"))
+	if err != nil {
+		level.Error(logger).Log("err", err, "message", "invalid replay end offsets")
+		panic(err)
+	}
+	return from, until, endOffsets
 }
@@ -4,15 +4,17 @@ go 1.14
 
 require (
 	github.com/Shopify/sarama v1.24.1
-	github.com/bsm/sarama-cluster v2.1.15+incompatible
 	github.com/datamountaineer/schema-registry v0.0.0-20170721142813-6240b64c5baa
 	github.com/go-kit/kit v0.10.0
+	github.com/golang/protobuf v1.5.0
+	github.com/jhump/protoreflect v1.12.0
 	github.com/klauspost/cpuid v1.2.1 // indirect
 	github.com/linkedin/goavro/v2 v2.10.0
 	github.com/olivere/elastic/v7 v7.0.25
-	github.com/onsi/ginkgo v1.8.0 // indirect
-	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.11.0
+	github.com/spaolacci/murmur3 v1.1.0
 	github.com/stretchr/testify v1.7.0
+	github.com/xdg-go/scram v1.0.2
+	github.com/xeipuuv/gojsonschema v1.2.0
 )
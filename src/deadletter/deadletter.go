@@ -0,0 +1,108 @@
+package deadletter
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/inloco/kafka-elasticsearch-injector/src/metrics"
+)
+
+type Stage string
+
+const (
+	StageDecode     Stage = "decode"
+	StageValidation Stage = "validation"
+	StageEncode     Stage = "encode"
+	StageIndex      Stage = "index"
+)
+
+// Headers added to every dead-lettered message, on top of the original ones.
+const (
+	HeaderStage     = "x-dlq-stage"
+	HeaderError     = "x-dlq-error"
+	HeaderTopic     = "x-dlq-source-topic"
+	HeaderPartition = "x-dlq-source-partition"
+	HeaderOffset    = "x-dlq-source-offset"
+)
+
+// Publisher republishes Kafka messages that could not be written to
+// Elasticsearch, so they can be inspected and replayed later.
+type Publisher interface {
+	Publish(msg *sarama.ConsumerMessage, stage Stage, cause error) error
+	Close() error
+}
+
+type kafkaPublisher struct {
+	producer         sarama.SyncProducer
+	topic            string
+	metricsPublisher metrics.MetricsPublisher
+}
+
+func (p *kafkaPublisher) Publish(msg *sarama.ConsumerMessage, stage Stage, cause error) error {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers = append(headers, *h)
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderStage), Value: []byte(stage)},
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderTopic), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(HeaderPartition), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		sarama.RecordHeader{Key: []byte(HeaderOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+
+	producerMsg := &sarama.ProducerMessage{
+		Topic:     p.topic,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+	}
+	// keep nil keys and values (tombstones) as nil instead of empty byte arrays
+	if msg.Key != nil {
+		producerMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		producerMsg.Value = sarama.ByteEncoder(msg.Value)
+	}
+	if _, _, err := p.producer.SendMessage(producerMsg); err != nil {
+		return err
+	}
+	p.metricsPublisher.IncrementDeadLettered(msg.Topic, string(stage), 1)
+	return nil
+}
+
+// Retriable reports whether publishing may succeed if it is tried again.
+// Messages over the broker size limits, invalid topics and missing ACLs fail
+// the same way on every attempt.
+func Retriable(err error) bool {
+	var kerr sarama.KError
+	if !errors.As(err, &kerr) {
+		return true
+	}
+	switch kerr {
+	case sarama.ErrMessageSizeTooLarge, sarama.ErrMessageSetSizeTooLarge, sarama.ErrInvalidMessageSize,
+		sarama.ErrInvalidTopic, sarama.ErrTopicAuthorizationFailed, sarama.ErrClusterAuthorizationFailed:
+		return false
+	}
+	return true
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.producer.Close()
+}
+
+func NewPublisher(brokers []string, topic string, config *sarama.Config, metrics metrics.MetricsPublisher) (Publisher, error) {
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaPublisher{
+		producer:         producer,
+		topic:            topic,
+		metricsPublisher: metrics,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package deadletter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/inloco/kafka-elasticsearch-injector/src/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingProducer struct {
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if p.err != nil {
+		return 0, 0, p.err
+	}
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+func (p *recordingProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *recordingProducer) Close() error {
+	return nil
+}
+
+var metricsPublisher = metrics.NewMetricsPublisher()
+
+func headerValue(msg *sarama.ProducerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	producer := &recordingProducer{}
+	publisher := &kafkaPublisher{producer: producer, topic: "my-dlq", metricsPublisher: metricsPublisher}
+	msg := &sarama.ConsumerMessage{
+		Topic:     "my-topic",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Timestamp: time.Now(),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("origin"), Value: []byte("test")}},
+	}
+
+	err := publisher.Publish(msg, StageDecode, errors.New("bad magic byte"))
+	if assert.NoError(t, err) && assert.Len(t, producer.sent, 1) {
+		sent := producer.sent[0]
+		assert.Equal(t, "my-dlq", sent.Topic)
+		assert.Equal(t, sarama.ByteEncoder("key"), sent.Key)
+		assert.Equal(t, sarama.ByteEncoder("value"), sent.Value)
+		assert.Equal(t, "test", headerValue(sent, "origin"))
+		assert.Equal(t, "decode", headerValue(sent, HeaderStage))
+		assert.Equal(t, "bad magic byte", headerValue(sent, HeaderError))
+		assert.Equal(t, "my-topic", headerValue(sent, HeaderTopic))
+		assert.Equal(t, "3", headerValue(sent, HeaderPartition))
+		assert.Equal(t, "42", headerValue(sent, HeaderOffset))
+	}
+}
+
+func TestPublisher_Publish_NilValue(t *testing.T) {
+	producer := &recordingProducer{}
+	publisher := &kafkaPublisher{producer: producer, topic: "my-dlq", metricsPublisher: metricsPublisher}
+
+	err := publisher.Publish(&sarama.ConsumerMessage{Topic: "my-topic"}, StageIndex, errors.New("rejected"))
+	if assert.NoError(t, err) && assert.Len(t, producer.sent, 1) {
+		assert.Nil(t, producer.sent[0].Key)
+		assert.Nil(t, producer.sent[0].Value)
+	}
+}
+
+func TestPublisher_Publish_ProducerError(t *testing.T) {
+	producer := &recordingProducer{err: errors.New("broker down")}
+	publisher := &kafkaPublisher{producer: producer, topic: "my-dlq", metricsPublisher: metricsPublisher}
+
+	err := publisher.Publish(&sarama.ConsumerMessage{Topic: "my-topic"}, StageIndex, errors.New("rejected"))
+	assert.Error(t, err)
+}
+
+func TestRetriable(t *testing.T) {
+	assert.True(t, Retriable(errors.New("broker down")))
+	assert.True(t, Retriable(sarama.ErrNotLeaderForPartition))
+	assert.False(t, Retriable(sarama.ErrMessageSizeTooLarge))
+	assert.False(t, Retriable(sarama.ErrTopicAuthorizationFailed))
+}
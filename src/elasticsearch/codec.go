@@ -1,7 +1,11 @@
 package elasticsearch
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -10,53 +14,164 @@ import (
 
 const typeDoc = "_doc"
 
+// kafkaTimestampField is the field data streams use as the document
+// timestamp, which the decoders fill with the Kafka record timestamp.
+const kafkaTimestampField = "@timestamp"
+
+// Codec encodes records to Elasticsearch documents. Records that can't be
+// encoded, e.g. because they lack a field the configuration requires, are
+// rejected on their own, without failing the other records.
 type Codec interface {
-	EncodeElasticRecords(records []*models.Record) ([]*models.ElasticRecord, error)
+	EncodeElasticRecords(records []*models.Record) ([]*models.ElasticRecord, []*RejectedRecord)
 }
 
 type basicCodec struct {
-	config Config
-	logger log.Logger
+	config     Config
+	logger     log.Logger
+	indexName  *indexName
+	docID      *docIDTemplate
+	transforms transformChain
+	pii        *piiMasker
 }
 
 func NewCodec(logger log.Logger, config Config) Codec {
-	return basicCodec{logger: logger, config: config}
+	if err := config.validate(); err != nil {
+		level.Error(logger).Log("err", err, "message", "invalid elasticsearch configuration")
+		panic(err)
+	}
+	codec := basicCodec{logger: logger, config: config}
+	if config.IndexNameTemplate != "" {
+		indexName, err := parseIndexName(config.IndexNameTemplate)
+		if err != nil {
+			level.Error(logger).Log("err", err, "message", "invalid index name template")
+			panic(err)
+		}
+		codec.indexName = indexName
+	}
+	if config.DocIDTemplate != "" {
+		docID, err := parseDocIDTemplate(config.DocIDTemplate)
+		if err != nil {
+			level.Error(logger).Log("err", err, "message", "invalid document ID template")
+			panic(err)
+		}
+		codec.docID = docID
+	}
+	if config.Transforms != "" {
+		transforms, err := parseTransforms(config.Transforms)
+		if err != nil {
+			level.Error(logger).Log("err", err, "message", "invalid transforms")
+			panic(err)
+		}
+		codec.transforms = transforms
+	}
+	pii, err := newPIIMasker(config)
+	if err != nil {
+		level.Error(logger).Log("err", err, "message", "invalid personal data masking")
+		panic(err)
+	}
+	codec.pii = pii
+	return codec
 }
 
-func (c basicCodec) EncodeElasticRecords(records []*models.Record) ([]*models.ElasticRecord, error) {
-	elasticRecords := make([]*models.ElasticRecord, len(records))
-	for idx, record := range records {
-		index, err := c.getDatabaseIndex(record)
-		if err != nil {
-			return nil, err
+func (c basicCodec) EncodeElasticRecords(records []*models.Record) ([]*models.ElasticRecord, []*RejectedRecord) {
+	elasticRecords := make([]*models.ElasticRecord, 0, len(records))
+	var rejected []*RejectedRecord
+	for _, record := range records {
+		if c.config.DataStream && record.Delete {
+			// documents can't be deleted through a data stream
+			level.Warn(c.logger).Log("message", "skipping delete on data stream", "topic", record.Topic, "offset", record.Offset)
+			continue
 		}
 
-		docID, err := c.getDatabaseDocID(record)
+		elasticRecord, err := c.encodeElasticRecord(record)
 		if err != nil {
-			return nil, err
+			rejected = append(rejected, &RejectedRecord{
+				Record: &models.ElasticRecord{Source: record},
+				Reason: err.Error(),
+			})
+			continue
 		}
+		elasticRecords = append(elasticRecords, elasticRecord)
+	}
 
-		elasticRecords[idx] = &models.ElasticRecord{
-			Index: index,
-			Type:  typeDoc,
-			ID:    docID,
-			Json:  record.FilteredFieldsJSON(c.config.BlacklistedColumns),
+	return elasticRecords, rejected
+}
+
+func (c basicCodec) encodeElasticRecord(record *models.Record) (*models.ElasticRecord, error) {
+	index, err := c.getDatabaseIndex(record)
+	if err != nil {
+		return nil, err
+	}
+
+	docID, err := c.getDatabaseDocID(record)
+	if err != nil {
+		return nil, err
+	}
+
+	version := record.Version
+	if version == nil && !c.config.DataStream {
+		version, err = c.getDocumentVersion(record)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return elasticRecords, nil
+	elasticRecord := &models.ElasticRecord{
+		Index:   index,
+		Type:    typeDoc,
+		ID:      docID,
+		OpType:  c.config.WriteMode,
+		Version: version,
+		Source:  record,
+	}
+	switch {
+	case c.config.DataStream:
+		// data streams are append-only, they only accept creates
+		elasticRecord.OpType = models.OpTypeCreate
+		elasticRecord.Version = nil
+	case record.Delete:
+		elasticRecord.OpType = models.OpTypeDelete
+	case version != nil:
+		// create and update only support internal versioning, update is
+		// rejected with a version source and Debezium rows are full images
+		elasticRecord.OpType = models.OpTypeIndex
+	case elasticRecord.OpType == "":
+		elasticRecord.OpType = models.OpTypeCreate
+	}
+	if !record.Delete {
+		// personal data is masked first, so transforms can't copy it
+		elasticRecord.Json = c.transforms.apply(c.pii.apply(record.FilteredFieldsJSON(c.config.BlacklistedColumns)))
+	}
+	if c.config.DataStream {
+		setDataStreamTimestamp(elasticRecord.Json, record)
+	}
+	return elasticRecord, nil
 }
 
 func (c basicCodec) getDatabaseIndex(record *models.Record) (string, error) {
+	if c.indexName != nil {
+		name, err := c.indexName.render(record)
+		if err != nil {
+			level.Error(c.logger).Log("err", err, "message", "Could not get index name from record.")
+			return "", err
+		}
+		// index names can't start with these characters
+		return strings.TrimLeft(sanitizeIndexName(c.config.IndexPrefix+name), "-_+"), nil
+	}
+
 	indexName := c.config.Index
 	if indexName == "" {
 		indexName = record.Topic
 	}
 
+	if c.config.DataStream {
+		return c.config.IndexPrefix + indexName, nil
+	}
+
 	indexColumn := c.config.IndexColumn
-	indexSuffix := record.FormatTimestampDay()
-	if c.config.TimeSuffix == TimeSuffixHour {
-		indexSuffix = record.FormatTimestampHour()
+	indexSuffix := formatTimeBucket(record.Timestamp, c.config.TimeSuffix)
+	if c.config.TimeSuffix == TimeSuffixNone && indexColumn == "" {
+		return c.config.IndexPrefix + indexName, nil
 	}
 	if indexColumn != "" {
 		newIndexSuffix, err := record.GetValueForField(indexColumn)
@@ -74,7 +189,67 @@ func (c basicCodec) getDatabaseIndex(record *models.Record) (string, error) {
 		indexSuffix), nil
 }
 
+// setDataStreamTimestamp makes sure the document has the timestamp required by
+// data streams, as a date Elasticsearch can parse. The Kafka record timestamp
+// is used when the record has none, or one that isn't an RFC 3339 date time or
+// a date.
+func setDataStreamTimestamp(doc map[string]interface{}, record *models.Record) {
+	switch timestamp := doc[kafkaTimestampField].(type) {
+	case int32, int64, float64, json.Number:
+		// epoch millis
+		return
+	case string:
+		if _, err := time.Parse(time.RFC3339, timestamp); err == nil {
+			return
+		}
+		if _, err := time.Parse("2006-01-02", timestamp); err == nil {
+			return
+		}
+	case time.Time:
+		doc[kafkaTimestampField] = timestamp.UTC().Format(time.RFC3339Nano)
+		return
+	}
+	doc[kafkaTimestampField] = record.Timestamp.UnixNano() / int64(time.Millisecond)
+}
+
+// getDatabaseDocID returns the document ID set when decoding the record, or
+// the one built from DocIDTemplate or DocIDColumn, or else the record
+// partition and offset. With DocIDHash, the ID is hashed, and records without
+// a template or column are identified by the hash of their payload.
 func (c basicCodec) getDatabaseDocID(record *models.Record) (string, error) {
+	docID, err := c.getRecordDocID(record)
+	if err != nil {
+		return "", err
+	}
+	if c.config.DocIDHash != "" {
+		input := []byte(docID)
+		if record.ID == "" && c.docID == nil && c.config.DocIDColumn == "" {
+			input, err = c.payloadHashInput(record)
+			if err != nil {
+				level.Error(c.logger).Log("err", err, "message", "Could not encode record payload to hash.")
+				return "", err
+			}
+		}
+		docID = hashDocID(c.config.DocIDHash, input)
+	}
+	if c.config.DocIDIncludeTopic {
+		docID = record.Topic + ":" + docID
+	}
+	return docID, nil
+}
+
+func (c basicCodec) getRecordDocID(record *models.Record) (string, error) {
+	if record.ID != "" {
+		return record.ID, nil
+	}
+	if c.docID != nil {
+		docID, err := c.docID.render(record)
+		if err != nil {
+			level.Error(c.logger).Log("err", err, "message", "Could not get doc id value from record.")
+			return "", err
+		}
+		return docID, nil
+	}
 	docID := record.GetId()
 
 	docIDColumn := c.config.DocIDColumn
@@ -88,3 +263,52 @@ func (c basicCodec) getDatabaseDocID(record *models.Record) (string, error) {
 	}
 	return docID, nil
 }
+
+// getDocumentVersion returns the external version of the document from the
+// configured version source, or nil if documents are not versioned.
+func (c basicCodec) getDocumentVersion(record *models.Record) (*int64, error) {
+	var version int64
+	switch c.config.VersionSource {
+	case VersionSourceOffset:
+		version = record.Offset
+	case VersionSourceTimestamp:
+		version = record.Timestamp.UnixNano() / int64(time.Millisecond)
+	case VersionSourceField:
+		value, ok := record.LookupField(c.config.VersionField)
+		if !ok {
+			err := fmt.Errorf("could not get version from column %s", c.config.VersionField)
+			level.Error(c.logger).Log("err", err, "message", "Could not get version value from record.")
+			return nil, err
+		}
+		v, ok := versionValue(value)
+		if !ok {
+			err := fmt.Errorf("value from column %s is not a number", c.config.VersionField)
+			level.Error(c.logger).Log("err", err, "message", "Could not get version value from record.")
+			return nil, err
+		}
+		version = v
+	default:
+		return nil, nil
+	}
+	return &version, nil
+}
+
+// versionValue converts a version field to an int64. JSON numbers are parsed
+// exactly, and protobuf int64 values are rendered as strings.
+func versionValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	case json.Number:
+		i, err := strconv.ParseInt(v.String(), 10, 64)
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
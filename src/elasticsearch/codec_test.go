@@ -1,6 +1,7 @@
 package elasticsearch
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"testing"
@@ -15,6 +16,31 @@ import (
 
 var codecLogger = logger_builder.NewLogger("elasticsearch-test")
 
+// userRecord is a record with nested objects, arrays and personal data, for
+// the tests of the document transforms and masking.
+func userRecord() *models.Record {
+	return &models.Record{
+		Topic:     "users",
+		Offset:    1,
+		Timestamp: time.Now(),
+		Json: map[string]interface{}{
+			"id":     "42",
+			"email":  "john@example.com",
+			"emails": []interface{}{"ab@b.com", nil},
+			"user": map[string]interface{}{
+				"name":     "alice",
+				"password": "secret",
+				"phone":    int64(5581987654321),
+				"address":  map[string]interface{}{"city": "Recife", "zip": "50000"},
+			},
+			"items": []interface{}{
+				map[string]interface{}{"sku": "a", "price": 10.0},
+			},
+			"nickname": nil,
+		},
+	}
+}
+
 func TestCodec_EncodeElasticRecords(t *testing.T) {
 	codec := &basicCodec{
 		config: Config{},
@@ -22,8 +48,8 @@ func TestCodec_EncodeElasticRecords(t *testing.T) {
 	}
 	record, id, value := fixtures.NewRecord(time.Now())
 
-	elasticRecords, err := codec.EncodeElasticRecords([]*models.Record{record})
-	if assert.NoError(t, err) && assert.Len(t, elasticRecords, 1) {
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
 		elasticRecord := elasticRecords[0]
 		assert.Equal(t, fmt.Sprintf("%s-%s", record.Topic, record.FormatTimestampDay()), elasticRecord.Index)
 		assert.Equal(t, "_doc", elasticRecord.Type)
@@ -42,8 +68,8 @@ func TestCodec_EncodeElasticRecordsHourSuffix(t *testing.T) {
 	}
 	record, id, value := fixtures.NewRecord(time.Now())
 
-	elasticRecords, err := codec.EncodeElasticRecords([]*models.Record{record})
-	if assert.NoError(t, err) && assert.Len(t, elasticRecords, 1) {
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
 		elasticRecord := elasticRecords[0]
 		assert.Equal(t, fmt.Sprintf("%s-%s", record.Topic, record.FormatTimestampHour()), elasticRecord.Index)
 		assert.Equal(t, "_doc", elasticRecord.Type)
@@ -60,8 +86,8 @@ func TestCodec_EncodeElasticRecords_ColumnsBlacklist(t *testing.T) {
 	}
 	record, _, _ := fixtures.NewRecord(time.Now())
 
-	elasticRecords, err := codec.EncodeElasticRecords([]*models.Record{record})
-	if assert.NoError(t, err) && assert.Len(t, elasticRecords, 1) {
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
 		elasticRecord := elasticRecords[0]
 		assert.Contains(t, elasticRecord.Json, "id")
 		assert.NotContains(t, elasticRecord.Json, "value")
@@ -77,8 +103,8 @@ func TestCodec_EncodeElasticRecords_IndexColumn(t *testing.T) {
 	}
 	record, id, _ := fixtures.NewRecord(time.Now())
 
-	elasticRecords, err := codec.EncodeElasticRecords([]*models.Record{record})
-	if assert.NoError(t, err) && assert.Len(t, elasticRecords, 1) {
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
 		elasticRecord := elasticRecords[0]
 		assert.Equal(t, fmt.Sprintf("%v-%v", indexPrefix, id), elasticRecord.Index)
 	}
@@ -91,8 +117,8 @@ func TestCodec_EncodeElasticRecords_InexistentIndexColumn(t *testing.T) {
 	}
 	record, _, _ := fixtures.NewRecord(time.Now())
 
-	_, err := codec.EncodeElasticRecords([]*models.Record{record})
-	assert.Error(t, err)
+	_, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	assert.Len(t, rejected, 1)
 }
 
 func TestCodec_EncodeElasticRecords_DocIDColumn(t *testing.T) {
@@ -102,8 +128,8 @@ func TestCodec_EncodeElasticRecords_DocIDColumn(t *testing.T) {
 	}
 	record, id, _ := fixtures.NewRecord(time.Now())
 
-	elasticRecords, err := codec.EncodeElasticRecords([]*models.Record{record})
-	if assert.NoError(t, err) && assert.Len(t, elasticRecords, 1) {
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
 		elasticRecord := elasticRecords[0]
 		assert.Equal(t, strconv.Itoa(int(id)), elasticRecord.ID)
 	}
@@ -116,6 +142,272 @@ func TestCodec_EncodeElasticRecords_InexistentDocIDColumn(t *testing.T) {
 	}
 	record, _, _ := fixtures.NewRecord(time.Now())
 
-	_, err := codec.EncodeElasticRecords([]*models.Record{record})
-	assert.Error(t, err)
+	_, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	assert.Len(t, rejected, 1)
+}
+
+func TestCodec_EncodeElasticRecords_NoTimeSuffix(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{IndexPrefix: "prefix-", TimeSuffix: TimeSuffixNone},
+		logger: codecLogger,
+	}
+	record, _, _ := fixtures.NewRecord(time.Now())
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		assert.Equal(t, "prefix-"+record.Topic, elasticRecords[0].Index)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_VersionedRecord(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{DocIDColumn: "id"},
+		logger: codecLogger,
+	}
+	record, _, _ := fixtures.NewRecord(time.Now())
+	record.ID = "row-1"
+	version := int64(1234)
+	record.Version = &version
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		elasticRecord := elasticRecords[0]
+		assert.Equal(t, "row-1", elasticRecord.ID)
+		assert.Equal(t, models.OpTypeIndex, elasticRecord.OpType)
+		assert.Equal(t, &version, elasticRecord.Version)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_DeleteRecord(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{},
+		logger: codecLogger,
+	}
+	record, _, _ := fixtures.NewRecord(time.Now())
+	record.ID = "row-1"
+	record.Delete = true
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		elasticRecord := elasticRecords[0]
+		assert.Equal(t, "row-1", elasticRecord.ID)
+		assert.Equal(t, models.OpTypeDelete, elasticRecord.OpType)
+		assert.Nil(t, elasticRecord.Json)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_TombstoneWithDocIDColumn(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{DocIDColumn: "user_id", TimeSuffix: TimeSuffixNone},
+		logger: codecLogger,
+	}
+	record := &models.Record{
+		Topic:     fixtures.DefaultTopic,
+		Timestamp: time.Now(),
+		Json:      map[string]interface{}{"user_id": "user-1"},
+		Delete:    true,
+	}
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		assert.Equal(t, "user-1", elasticRecords[0].ID)
+		assert.Equal(t, models.OpTypeDelete, elasticRecords[0].OpType)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_WriteMode(t *testing.T) {
+	for _, writeMode := range []string{models.OpTypeCreate, models.OpTypeIndex, models.OpTypeUpdate} {
+		codec := &basicCodec{
+			config: Config{WriteMode: writeMode},
+			logger: codecLogger,
+		}
+		record, _, _ := fixtures.NewRecord(time.Now())
+
+		elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+		if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+			assert.Equal(t, writeMode, elasticRecords[0].OpType)
+		}
+	}
+}
+
+func TestNewCodec_InvalidConfig(t *testing.T) {
+	for _, config := range []Config{
+		{WriteMode: "upsert"},
+		{VersionSource: "lsn"},
+		{DocIDHash: "md5"},
+		{WriteMode: models.OpTypeUpdate, VersionSource: VersionSourceOffset},
+		{PIIMaskFields: []string{"email"}, DocIDColumn: "email"},
+		{PIIRedactFields: []string{"user"}, DocIDTemplate: "{tenant}:{user.id}"},
+		{PIIHashFields: []string{"user.email"}, IndexColumn: "user.email", DocIDHash: DocIDHashSHA1},
+		{PIIMaskFields: []string{"tenant"}, IndexNameTemplate: "logs-{field:tenant.name}"},
+	} {
+		assert.Panics(t, func() { NewCodec(codecLogger, config) }, fmt.Sprintf("%+v", config))
+	}
+}
+
+func TestNewCodec_PIIInHashedDocIDs(t *testing.T) {
+	config := Config{PIIMaskFields: []string{"email"}, DocIDColumn: "email", DocIDHash: DocIDHashSHA1}
+	assert.NotPanics(t, func() { NewCodec(codecLogger, config) })
+}
+
+func TestCodec_EncodeElasticRecords_VersionSource(t *testing.T) {
+	record, id, _ := fixtures.NewRecord(time.Now())
+	tests := []struct {
+		config   Config
+		expected int64
+	}{
+		{Config{VersionSource: VersionSourceOffset}, record.Offset},
+		{Config{VersionSource: VersionSourceTimestamp}, record.Timestamp.UnixNano() / int64(time.Millisecond)},
+		{Config{VersionSource: VersionSourceField, VersionField: "id"}, int64(id)},
+	}
+	for _, tt := range tests {
+		codec := &basicCodec{config: tt.config, logger: codecLogger}
+
+		elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+		if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+			assert.Equal(t, models.OpTypeIndex, elasticRecords[0].OpType)
+			if assert.NotNil(t, elasticRecords[0].Version) {
+				assert.Equal(t, tt.expected, *elasticRecords[0].Version)
+			}
+		}
+	}
+}
+
+func TestCodec_EncodeElasticRecords_VersionFieldEncodings(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{VersionSource: VersionSourceField, VersionField: "lsn"},
+		logger: codecLogger,
+	}
+	for _, lsn := range []interface{}{json.Number("9007199254740993"), "9007199254740993"} {
+		record := &models.Record{Topic: "events", Json: map[string]interface{}{"lsn": lsn}}
+
+		elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+		if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) && assert.NotNil(t, elasticRecords[0].Version) {
+			assert.Equal(t, int64(9007199254740993), *elasticRecords[0].Version)
+			assert.Nil(t, record.Version)
+		}
+	}
+}
+
+func TestCodec_EncodeElasticRecords_InexistentVersionField(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{VersionSource: VersionSourceField, VersionField: "invalid"},
+		logger: codecLogger,
+	}
+	record, _, _ := fixtures.NewRecord(time.Now())
+
+	_, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	assert.Len(t, rejected, 1)
+}
+
+func TestCodec_EncodeElasticRecords_RejectsRecordsAlone(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{VersionSource: VersionSourceField, VersionField: "id"},
+		logger: codecLogger,
+	}
+	record, _, _ := fixtures.NewRecord(time.Now())
+	invalid := &models.Record{Topic: "events", Json: map[string]interface{}{"name": "no id"}}
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record, invalid})
+	if assert.Len(t, elasticRecords, 1) && assert.Len(t, rejected, 1) {
+		assert.Equal(t, record, elasticRecords[0].Source)
+		assert.Equal(t, invalid, rejected[0].Record.Source)
+		assert.Equal(t, "could not get version from column id", rejected[0].Reason)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_DataStream(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{
+			Index:         "events",
+			IndexPrefix:   "logs-",
+			DataStream:    true,
+			WriteMode:     models.OpTypeIndex,
+			VersionSource: VersionSourceOffset,
+		},
+		logger: codecLogger,
+	}
+	record, _, _ := fixtures.NewRecord(time.Now())
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		elasticRecord := elasticRecords[0]
+		assert.Equal(t, "logs-events", elasticRecord.Index)
+		assert.Equal(t, models.OpTypeCreate, elasticRecord.OpType)
+		assert.Nil(t, elasticRecord.Version)
+		assert.Equal(t, record.Timestamp.UnixNano()/int64(time.Millisecond), elasticRecord.Json["@timestamp"])
+	}
+}
+
+func TestCodec_EncodeElasticRecords_DataStreamTimestamp(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{DataStream: true},
+		logger: codecLogger,
+	}
+	eventTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("BRT", -3*60*60))
+	kafkaTime := time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)
+	kafkaMillis := kafkaTime.UnixNano() / int64(time.Millisecond)
+	tests := []struct {
+		timestamp interface{}
+		expected  interface{}
+	}{
+		{int64(1234), int64(1234)},
+		{"2021-03-04T08:06:07Z", "2021-03-04T08:06:07Z"},
+		{"2021-03-04T05:06:07.123-03:00", "2021-03-04T05:06:07.123-03:00"},
+		{"2021-03-04", "2021-03-04"},
+		{eventTime, "2021-03-04T08:06:07Z"},
+		{"n/a", kafkaMillis},
+		{"", kafkaMillis},
+	}
+	for _, tt := range tests {
+		record, _, _ := fixtures.NewRecord(kafkaTime)
+		record.Json["@timestamp"] = tt.timestamp
+
+		elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+		if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+			assert.Equal(t, tt.expected, elasticRecords[0].Json["@timestamp"])
+		}
+	}
+}
+
+func TestCodec_EncodeElasticRecords_DataStreamSkipsDeletes(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{DataStream: true},
+		logger: codecLogger,
+	}
+	record, _, _ := fixtures.NewRecord(time.Now())
+	deleted, _, _ := fixtures.NewRecord(time.Now())
+	deleted.Delete = true
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record, deleted})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		assert.Equal(t, record, elasticRecords[0].Source)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_NestedColumns(t *testing.T) {
+	codec := &basicCodec{
+		config: Config{
+			IndexColumn:        "tenant.id",
+			DocIDColumn:        "$.user['id']",
+			BlacklistedColumns: []string{"user.password"},
+		},
+		logger: codecLogger,
+	}
+	record := &models.Record{
+		Topic:     fixtures.DefaultTopic,
+		Timestamp: time.Now(),
+		Json: map[string]interface{}{
+			"tenant": map[string]interface{}{"id": int64(7)},
+			"user":   map[string]interface{}{"id": 1.5e9, "password": "secret"},
+		},
+	}
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		elasticRecord := elasticRecords[0]
+		assert.Equal(t, fmt.Sprintf("%s-7", record.Topic), elasticRecord.Index)
+		assert.Equal(t, "1500000000", elasticRecord.ID)
+		assert.Equal(t, map[string]interface{}{"id": 1.5e9}, elasticRecord.Json["user"])
+	}
 }
@@ -1,34 +1,85 @@
 package elasticsearch
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
 )
 
 type TimeIndexSuffix int
 
 const (
-	TimeSuffixDay  TimeIndexSuffix = 0
-	TimeSuffixHour TimeIndexSuffix = 1
+	TimeSuffixDay   TimeIndexSuffix = 0
+	TimeSuffixHour  TimeIndexSuffix = 1
+	TimeSuffixNone  TimeIndexSuffix = 2
+	TimeSuffixWeek  TimeIndexSuffix = 3
+	TimeSuffixMonth TimeIndexSuffix = 4
+	TimeSuffixYear  TimeIndexSuffix = 5
+)
+
+// Sources of external document versions.
+const (
+	VersionSourceOffset    = "offset"
+	VersionSourceTimestamp = "timestamp"
+	VersionSourceField     = "field"
 )
 
 type Config struct {
-	Host               string
-	User               string
-	Pwd                string
-	IgnoreCertificate  bool
-	Scheme             string
-	Index              string
-	IndexPrefix        string
-	IndexColumn        string
-	DocIDColumn        string
+	Host              string
+	User              string
+	Pwd               string
+	IgnoreCertificate bool
+	Scheme            string
+	Index             string
+	IndexPrefix       string
+	IndexColumn       string
+	// IndexNameTemplate builds index names from the record, e.g.
+	// "logs-{topic}-{field:tenant.id}-{ts:month}", see parseIndexName.
+	IndexNameTemplate string
+	DocIDColumn       string
+	// DocIDTemplate builds document IDs from several fields, e.g.
+	// "{tenant}:{user_id}", instead of DocIDColumn.
+	DocIDTemplate      string
+	DocIDIncludeTopic  bool
+	DocIDHash          string
 	BlacklistedColumns []string
-	BulkTimeout        time.Duration
-	Backoff            time.Duration
-	TimeSuffix         TimeIndexSuffix
-	DisableSniffing    bool
+	// MetadataField is the document field holding the Kafka metadata of
+	// records decoded with it.
+	MetadataField string
+	// Transforms is the transform chain applied to documents, see
+	// parseTransforms.
+	Transforms string
+	// PIIRedactFields, PIIMaskFields and PIIHashFields hide personal data in
+	// documents, see piiMasker.
+	PIIRedactFields []string
+	PIIMaskFields   []string
+	PIIMaskKeepLast int
+	PIIHashFields   []string
+	PIIHashSalt     string
+	PIIHashSaltFile string
+	BulkTimeout     time.Duration
+	Backoff         time.Duration
+	TimeSuffix      TimeIndexSuffix
+	DisableSniffing bool
+	WriteMode       string
+	VersionSource   string
+	VersionField    string
+	// DataStream writes records to data streams instead of time suffixed
+	// indexes, see dataStreams for the bootstrapped templates and policies.
+	DataStream          bool
+	DataStreamBootstrap bool
+	ILMPolicy           string
+	ILMRolloverMaxAge   string
+	ILMRolloverMaxSize  string
+	ILMDeleteAfter      string
+	// TemplateFromSchema installs index templates generated from the Avro
+	// schemas of topics on startup, mapping strings as TemplateStringType.
+	TemplateFromSchema bool
+	TemplateStringType string
 }
 
 func NewConfig() Config {
@@ -53,6 +104,14 @@ func NewConfig() Config {
 		switch suffix {
 		case "hour":
 			timeSuffix = TimeSuffixHour
+		case "none":
+			timeSuffix = TimeSuffixNone
+		case "week":
+			timeSuffix = TimeSuffixWeek
+		case "month":
+			timeSuffix = TimeSuffixMonth
+		case "year":
+			timeSuffix = TimeSuffixYear
 		}
 	}
 	ignoreCert := false
@@ -79,20 +138,129 @@ func NewConfig() Config {
 		}
 	}
 
+	// unknown write modes, version sources and hashes are rejected by
+	// NewCodec, see Config.validate
+	writeMode := getEnvOrDefault("ES_WRITE_MODE", models.OpTypeCreate)
+
+	dataStream := false
+	if c := os.Getenv("ES_DATA_STREAM"); c != "" {
+		res, err := strconv.ParseBool(c)
+		if err == nil {
+			dataStream = res
+		}
+	}
+
+	dataStreamBootstrap := false
+	if c := os.Getenv("ES_DATA_STREAM_BOOTSTRAP"); c != "" {
+		res, err := strconv.ParseBool(c)
+		if err == nil {
+			dataStreamBootstrap = res
+		}
+	}
+
+	docIDIncludeTopic := false
+	if c := os.Getenv("ES_DOC_ID_INCLUDE_TOPIC"); c != "" {
+		res, err := strconv.ParseBool(c)
+		if err == nil {
+			docIDIncludeTopic = res
+		}
+	}
+
+	maskKeepLast := 4
+	if c := os.Getenv("ES_PII_MASK_KEEP_LAST"); c != "" {
+		res, err := strconv.Atoi(c)
+		if err == nil && res >= 0 {
+			maskKeepLast = res
+		}
+	}
+
+	templateFromSchema := false
+	if c := os.Getenv("ES_TEMPLATE_FROM_SCHEMA"); c != "" {
+		res, err := strconv.ParseBool(c)
+		if err == nil {
+			templateFromSchema = res
+		}
+	}
+
+	templateStringType := "keyword"
+	if t := os.Getenv("ES_TEMPLATE_STRING_TYPE"); t != "" {
+		switch t {
+		case "text":
+			templateStringType = t
+		}
+	}
+
 	return Config{
-		Host:               os.Getenv("ELASTICSEARCH_HOST"),
-		User:               os.Getenv("ELASTICSEARCH_USER"),
-		Pwd:                os.Getenv("ELASTICSEARCH_PASSWORD"),
-		IgnoreCertificate:  ignoreCert,
-		Scheme:             scheme,
-		Index:              os.Getenv("ES_INDEX"),
-		IndexPrefix:        os.Getenv("ES_INDEX_PREFIX"),
-		IndexColumn:        os.Getenv("ES_INDEX_COLUMN"),
-		DocIDColumn:        os.Getenv("ES_DOC_ID_COLUMN"),
-		BlacklistedColumns: strings.Split(os.Getenv("ES_BLACKLISTED_COLUMNS"), ","),
-		BulkTimeout:        timeout,
-		Backoff:            backoff,
-		TimeSuffix:         timeSuffix,
-		DisableSniffing:    disableSniff,
+		Host:                os.Getenv("ELASTICSEARCH_HOST"),
+		User:                os.Getenv("ELASTICSEARCH_USER"),
+		Pwd:                 os.Getenv("ELASTICSEARCH_PASSWORD"),
+		IgnoreCertificate:   ignoreCert,
+		Scheme:              scheme,
+		Index:               os.Getenv("ES_INDEX"),
+		IndexPrefix:         os.Getenv("ES_INDEX_PREFIX"),
+		IndexColumn:         os.Getenv("ES_INDEX_COLUMN"),
+		IndexNameTemplate:   os.Getenv("ES_INDEX_NAME_TEMPLATE"),
+		DocIDColumn:         os.Getenv("ES_DOC_ID_COLUMN"),
+		DocIDTemplate:       os.Getenv("ES_DOC_ID_TEMPLATE"),
+		DocIDIncludeTopic:   docIDIncludeTopic,
+		DocIDHash:           os.Getenv("ES_DOC_ID_HASH"),
+		BlacklistedColumns:  strings.Split(os.Getenv("ES_BLACKLISTED_COLUMNS"), ","),
+		MetadataField:       getEnvOrDefault("KAFKA_CONSUMER_METADATA_FIELD", "kafka"),
+		Transforms:          os.Getenv("ES_TRANSFORMS"),
+		PIIRedactFields:     strings.Split(os.Getenv("ES_PII_REDACT_FIELDS"), ","),
+		PIIMaskFields:       strings.Split(os.Getenv("ES_PII_MASK_FIELDS"), ","),
+		PIIMaskKeepLast:     maskKeepLast,
+		PIIHashFields:       strings.Split(os.Getenv("ES_PII_HASH_FIELDS"), ","),
+		PIIHashSalt:         os.Getenv("ES_PII_HASH_SALT"),
+		PIIHashSaltFile:     os.Getenv("ES_PII_HASH_SALT_FILE"),
+		BulkTimeout:         timeout,
+		Backoff:             backoff,
+		TimeSuffix:          timeSuffix,
+		DisableSniffing:     disableSniff,
+		WriteMode:           writeMode,
+		VersionSource:       os.Getenv("ES_VERSION_SOURCE"),
+		VersionField:        os.Getenv("ES_VERSION_FIELD"),
+		DataStream:          dataStream,
+		DataStreamBootstrap: dataStreamBootstrap,
+		ILMPolicy:           getEnvOrDefault("ES_ILM_POLICY", defaultILMPolicy),
+		ILMRolloverMaxAge:   getEnvOrDefault("ES_ILM_ROLLOVER_MAX_AGE", "1d"),
+		ILMRolloverMaxSize:  getEnvOrDefault("ES_ILM_ROLLOVER_MAX_SIZE", "50gb"),
+		ILMDeleteAfter:      os.Getenv("ES_ILM_DELETE_AFTER"),
+		TemplateFromSchema:  templateFromSchema,
+		TemplateStringType:  templateStringType,
+	}
+}
+
+// validate checks the settings that have a fixed set of values, so typos fail
+// on startup instead of silently falling back to the defaults, and the
+// combinations of settings that would lose or leak data.
+func (c Config) validate() error {
+	switch c.WriteMode {
+	case "", models.OpTypeCreate, models.OpTypeIndex, models.OpTypeUpdate:
+	default:
+		return fmt.Errorf("unknown write mode %q", c.WriteMode)
+	}
+	switch c.VersionSource {
+	case "", VersionSourceOffset, VersionSourceTimestamp, VersionSourceField:
+	default:
+		return fmt.Errorf("unknown version source %q", c.VersionSource)
+	}
+	switch c.DocIDHash {
+	case "", DocIDHashSHA1, DocIDHashMurmur3:
+	default:
+		return fmt.Errorf("unknown document ID hash %q", c.DocIDHash)
+	}
+	// versioned documents are written with index semantics, which would
+	// replace documents instead of merging records into them
+	if c.WriteMode == models.OpTypeUpdate && c.VersionSource != "" {
+		return fmt.Errorf("write mode %q doesn't support version source %q", c.WriteMode, c.VersionSource)
+	}
+	return checkPIINotInNames(c)
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return defaultValue
 }
@@ -0,0 +1,87 @@
+package elasticsearch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+	"github.com/olivere/elastic/v7"
+)
+
+const defaultILMPolicy = "kafka-elasticsearch-injector"
+
+// dataStreams bootstraps the index template and ILM policy of every data
+// stream before its first write. Streams are created by Elasticsearch itself
+// on the first document written to a name matching a data stream template.
+type dataStreams struct {
+	lock         sync.Mutex
+	policyExists bool
+	bootstrapped sync.Map
+}
+
+func (d recordDatabase) bootstrapDataStreams(records []*models.ElasticRecord) error {
+	if !d.config.DataStream || !d.config.DataStreamBootstrap {
+		return nil
+	}
+	for _, record := range records {
+		if _, ok := d.dataStreams.bootstrapped.Load(record.Index); ok {
+			continue
+		}
+		if err := d.bootstrapDataStream(record.Index); err != nil {
+			level.Error(d.logger).Log("err", err, "message", "could not bootstrap data stream", "data_stream", record.Index)
+			return err
+		}
+		d.dataStreams.bootstrapped.Store(record.Index, true)
+	}
+	return nil
+}
+
+// bootstrapDataStream creates the ILM policy and the index template of a data
+// stream, unless they already exist. Existing ones are never overwritten, so
+// they can be managed outside of the injector.
+func (d recordDatabase) bootstrapDataStream(name string) error {
+	d.dataStreams.lock.Lock()
+	defer d.dataStreams.lock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.BulkTimeout)
+	defer cancel()
+
+	if !d.dataStreams.policyExists {
+		_, err := d.GetClient().XPackIlmGetLifecycle().Policy(d.config.ILMPolicy).Do(ctx)
+		if elastic.IsNotFound(err) {
+			level.Info(d.logger).Log("message", "creating ILM policy", "policy", d.config.ILMPolicy)
+			_, err = d.GetClient().XPackIlmPutLifecycle().Policy(d.config.ILMPolicy).BodyJson(d.ilmPolicy()).Do(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		d.dataStreams.policyExists = true
+	}
+
+	mappings := map[string]interface{}{
+		"properties": map[string]interface{}{
+			kafkaTimestampField: map[string]interface{}{"type": "date"},
+		},
+	}
+	_, err := d.PutIndexTemplate(name, indexTemplate(d.config, name, mappings))
+	return err
+}
+
+func (d recordDatabase) ilmPolicy() map[string]interface{} {
+	rollover := map[string]interface{}{"max_age": d.config.ILMRolloverMaxAge}
+	if d.config.ILMRolloverMaxSize != "" {
+		rollover["max_size"] = d.config.ILMRolloverMaxSize
+	}
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{"rollover": rollover},
+		},
+	}
+	if d.config.ILMDeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": d.config.ILMDeleteAfter,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	return map[string]interface{}{"policy": map[string]interface{}{"phases": phases}}
+}
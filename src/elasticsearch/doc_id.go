@@ -0,0 +1,97 @@
+package elasticsearch
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+	"github.com/spaolacci/murmur3"
+)
+
+// Hash functions of document IDs.
+const (
+	DocIDHashSHA1    = "sha1"
+	DocIDHashMurmur3 = "murmur3"
+)
+
+// docIDTemplate is a parsed document ID template, a sequence of literal text
+// and {field} parts, e.g. "{tenant}:{user.id}".
+type docIDTemplate struct {
+	parts []docIDPart
+}
+
+type docIDPart struct {
+	literal string
+	field   string
+}
+
+func parseDocIDTemplate(template string) (*docIDTemplate, error) {
+	t := &docIDTemplate{}
+	for rest := template; rest != ""; {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			start = len(rest)
+		}
+		if strings.Contains(rest[:start], "}") {
+			return nil, fmt.Errorf("unexpected } in document ID template %q", template)
+		}
+		if start > 0 {
+			t.parts = append(t.parts, docIDPart{literal: rest[:start]})
+		}
+		if start == len(rest) {
+			break
+		}
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in document ID template %q", template)
+		}
+		field := rest[start+1 : end]
+		if field == "" {
+			return nil, fmt.Errorf("empty field in document ID template %q", template)
+		}
+		t.parts = append(t.parts, docIDPart{field: field})
+		rest = rest[end+1:]
+	}
+	return t, nil
+}
+
+func (t *docIDTemplate) render(record *models.Record) (string, error) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value, err := record.GetValueForField(part.field)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
+
+// hashDocID returns the hex encoded hash of value.
+func hashDocID(hash string, value []byte) string {
+	if hash == DocIDHashMurmur3 {
+		h1, h2 := murmur3.Sum128(value)
+		return fmt.Sprintf("%016x%016x", h1, h2)
+	}
+	sum := sha1.Sum(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadHashInput is the record JSON documents are deduplicated by, without
+// the fields that change when the same payload is produced again: the Kafka
+// timestamp and metadata.
+func (c basicCodec) payloadHashInput(record *models.Record) ([]byte, error) {
+	payload := record.FilteredFieldsJSON(append([]string{kafkaTimestampField}, c.config.BlacklistedColumns...))
+	if record.Metadata != nil {
+		delete(payload, c.config.MetadataField)
+	}
+	// maps are encoded with sorted keys, so equal payloads are equal JSON
+	return json.Marshal(payload)
+}
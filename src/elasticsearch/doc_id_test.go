@@ -0,0 +1,117 @@
+package elasticsearch
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/kafka/fixtures"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// Document IDs are persistent, so hashes must never change.
+func TestHashDocID_Murmur3(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"", "00000000000000000000000000000000"},
+		{"hello", "cbd8a7b341bd9b025b1e906a48ae1d19"},
+		{"hello, world", "342fac623a5ebc8e4cdcbc079642414d"},
+		{"19 Jan 2038 at 3:14:07 AM", "b89e5988b737affc664fc2950231b2cb"},
+		{"The quick brown fox jumps over the lazy dog.", "cd99481f9ee902c9695da1a38987b6e7"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, hashDocID(DocIDHashMurmur3, []byte(tt.data)), tt.data)
+	}
+}
+
+func TestParseDocIDTemplate_Invalid(t *testing.T) {
+	for _, template := range []string{"{tenant", "tenant}", "{tenant}:{}"} {
+		_, err := parseDocIDTemplate(template)
+		assert.Error(t, err, template)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_DocIDTemplate(t *testing.T) {
+	codec := NewCodec(codecLogger, Config{DocIDTemplate: "{tenant}:{user.id}", DocIDIncludeTopic: true})
+	record := &models.Record{
+		Topic:     "users",
+		Timestamp: time.Now(),
+		Json: map[string]interface{}{
+			"tenant": "acme",
+			"user":   map[string]interface{}{"id": int64(42)},
+		},
+	}
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		assert.Equal(t, "users:acme:42", elasticRecords[0].ID)
+	}
+
+	delete(record.Json, "tenant")
+	_, rejected = codec.EncodeElasticRecords([]*models.Record{record})
+	assert.Len(t, rejected, 1)
+}
+
+func TestCodec_EncodeElasticRecords_DefaultDocIDWithTopic(t *testing.T) {
+	codec := NewCodec(codecLogger, Config{DocIDIncludeTopic: true})
+	record, _, _ := fixtures.NewRecord(time.Now())
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		assert.Equal(t, fmt.Sprintf("%s:%d:%d", record.Topic, record.Partition, record.Offset), elasticRecords[0].ID)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_HashedDocID(t *testing.T) {
+	codec := NewCodec(codecLogger, Config{DocIDTemplate: "{tenant}:{user_id}", DocIDHash: DocIDHashSHA1})
+	record := &models.Record{
+		Topic:     "users",
+		Timestamp: time.Now(),
+		Json:      map[string]interface{}{"tenant": "acme", "user_id": "42"},
+	}
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		sum := sha1.Sum([]byte("acme:42"))
+		assert.Equal(t, hex.EncodeToString(sum[:]), elasticRecords[0].ID)
+	}
+}
+
+func TestCodec_EncodeElasticRecords_PayloadHashDocID(t *testing.T) {
+	codec := NewCodec(codecLogger, Config{DocIDHash: DocIDHashMurmur3, MetadataField: "_kafka"})
+	metadata := map[string]interface{}{"offset": int64(1)}
+	record := &models.Record{
+		Topic:     "events",
+		Offset:    1,
+		Timestamp: time.Now(),
+		Json:      map[string]interface{}{"id": "event-1", "value": 1.5, "@timestamp": int64(1), "_kafka": metadata},
+		Metadata:  metadata,
+	}
+	otherMetadata := map[string]interface{}{"offset": int64(2)}
+	duplicate := &models.Record{
+		Topic:     "events",
+		Offset:    2,
+		Timestamp: time.Now(),
+		Json:      map[string]interface{}{"value": 1.5, "id": "event-1", "@timestamp": int64(2), "_kafka": otherMetadata},
+		Metadata:  otherMetadata,
+	}
+	different := &models.Record{
+		Topic:     "events",
+		Offset:    3,
+		Timestamp: time.Now(),
+		Json:      map[string]interface{}{"id": "event-2", "value": 1.5},
+	}
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record, duplicate, different})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 3) {
+		assert.Len(t, elasticRecords[0].ID, 32)
+		assert.Equal(t, elasticRecords[0].ID, elasticRecords[1].ID)
+		assert.NotEqual(t, elasticRecords[0].ID, elasticRecords[2].ID)
+	}
+}
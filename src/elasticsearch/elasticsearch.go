@@ -19,6 +19,14 @@ import (
 
 var esClient *elastic.Client
 
+// externalVersionType lets a document be written again with the same version,
+// so replaying records is idempotent, but never with an older one.
+const externalVersionType = "external_gte"
+
+// updateRetriesOnConflict is how many times Elasticsearch retries a partial
+// update when the document changes between reading and writing it.
+const updateRetriesOnConflict = 3
+
 type basicDatabase interface {
 	GetClient() *elastic.Client
 	CloseClient()
@@ -27,6 +35,7 @@ type basicDatabase interface {
 type RecordDatabase interface {
 	basicDatabase
 	Insert(records []*models.ElasticRecord) (*InsertResponse, error)
+	PutIndexTemplate(name string, template map[string]interface{}) (bool, error)
 	ReadinessCheck() bool
 }
 
@@ -34,6 +43,7 @@ type recordDatabase struct {
 	metricsPublisher metrics.MetricsPublisher
 	logger           log.Logger
 	config           Config
+	dataStreams      *dataStreams
 }
 
 func (d recordDatabase) GetClient() *elastic.Client {
@@ -71,10 +81,21 @@ func (d recordDatabase) CloseClient() {
 type InsertResponse struct {
 	AlreadyExists []string
 	Retry         []*models.ElasticRecord
+	Rejected      []*RejectedRecord
 	Backoff       bool
 }
 
+// RejectedRecord is a record Elasticsearch refused to index (e.g. a mapping
+// error), which would fail again if retried.
+type RejectedRecord struct {
+	Record *models.ElasticRecord
+	Reason string
+}
+
 func (d recordDatabase) Insert(records []*models.ElasticRecord) (*InsertResponse, error) {
+	if err := d.bootstrapDataStreams(records); err != nil {
+		return nil, err
+	}
 	bulkRequest, err := d.buildBulkRequest(records)
 	if err != nil {
 		return nil, err
@@ -93,50 +114,81 @@ func (d recordDatabase) Insert(records []*models.ElasticRecord) (*InsertResponse
 		return nil, err
 	}
 	if res.Errors {
-		created := res.Created()
 		var alreadyExistsIds []string
-		for _, c := range created {
-			if c.Status == http.StatusConflict {
-				alreadyExistsIds = append(alreadyExistsIds, c.Id)
-			}
-		}
-		if len(alreadyExistsIds) > 0 {
-			level.Warn(d.logger).Log("message", "document already exists", "doc_count", len(alreadyExistsIds))
-		}
-		failed := res.Failed()
 		var retry []*models.ElasticRecord
+		var rejected []*RejectedRecord
 		overloaded := false
-		if len(failed) > 0 {
-			recordMap := make(map[string]*models.ElasticRecord)
-			for _, rec := range records {
-				recordMap[rec.ID] = rec
+		// bulk response items are in the same order as the request ones, a
+		// batch may have several operations for the same document ID.
+		for i, item := range res.Items {
+			if i >= len(records) {
+				break
 			}
-			for _, f := range failed {
+			rec := records[i]
+			for opType, f := range item {
+				if f.Status >= 200 && f.Status <= 299 {
+					continue
+				}
+				if f.Status == http.StatusConflict && opType == models.OpTypeCreate {
+					alreadyExistsIds = append(alreadyExistsIds, f.Id)
+				}
+				if f.Status == http.StatusNotFound && opType == models.OpTypeDelete {
+					// already deleted
+					continue
+				}
 				if f.Status == http.StatusBadRequest {
 					_ = level.Debug(d.logger).Log("message", "elasticsearch bad requests", "err", f)
-					d.metricsPublisher.ElasticsearchBadRequests(1)
+					d.metricsPublisher.ElasticsearchBadRequests(recordTopic(rec), 1)
+					rejected = append(rejected, &RejectedRecord{Record: rec, Reason: failureReason(f)})
+					continue
+				}
+				if f.Status == http.StatusConflict && rec.Version != nil {
+					// a newer version of the document is already stored
+					_ = level.Debug(d.logger).Log("message", "elasticsearch outdated document", "err", f)
+					d.metricsPublisher.ElasticsearchOutdated(recordTopic(rec), 1)
 					continue
 				}
 				if f.Status == http.StatusConflict {
 					_ = level.Debug(d.logger).Log("message", "elasticsearch conflicts", "err", f)
-					d.metricsPublisher.ElasticsearchConflicts(1)
+					d.metricsPublisher.ElasticsearchConflicts(recordTopic(rec), 1)
 					continue
 				}
-				retry = append(retry, recordMap[f.Id])
+				retry = append(retry, rec)
 				if f.Status == http.StatusTooManyRequests {
 					//es is overloaded, backoff
 					overloaded = true
 				}
 			}
-			if overloaded {
-				level.Warn(d.logger).Log("message", "insert failed: elasticsearch is overloaded", "retry_count", len(retry))
-			}
 		}
-		d.metricsPublisher.ElasticsearchRetries(len(retry))
-		return &InsertResponse{alreadyExistsIds, retry, overloaded}, nil
+		if len(alreadyExistsIds) > 0 {
+			level.Warn(d.logger).Log("message", "document already exists", "doc_count", len(alreadyExistsIds))
+		}
+		if overloaded {
+			level.Warn(d.logger).Log("message", "insert failed: elasticsearch is overloaded", "retry_count", len(retry))
+		}
+		for _, rec := range retry {
+			d.metricsPublisher.ElasticsearchRetries(recordTopic(rec), 1)
+		}
+		return &InsertResponse{alreadyExistsIds, retry, rejected, overloaded}, nil
 	}
 
-	return &InsertResponse{[]string{}, []*models.ElasticRecord{}, false}, nil
+	return &InsertResponse{[]string{}, []*models.ElasticRecord{}, []*RejectedRecord{}, false}, nil
+}
+
+// recordTopic returns the Kafka topic a record was consumed from, used to
+// label metrics.
+func recordTopic(record *models.ElasticRecord) string {
+	if record == nil || record.Source == nil {
+		return ""
+	}
+	return record.Source.Topic
+}
+
+func failureReason(item *elastic.BulkResponseItem) string {
+	if item.Error == nil {
+		return http.StatusText(item.Status)
+	}
+	return fmt.Sprintf("%s: %s", item.Error.Type, item.Error.Reason)
 }
 
 func (d recordDatabase) ReadinessCheck() bool {
@@ -152,11 +204,38 @@ func (d recordDatabase) ReadinessCheck() bool {
 func (d recordDatabase) buildBulkRequest(records []*models.ElasticRecord) (*elastic.BulkService, error) {
 	bulkRequest := d.GetClient().Bulk()
 	for _, record := range records {
-		bulkRequest.Add(elastic.NewBulkIndexRequest().OpType("create").
+		if record.OpType == models.OpTypeDelete {
+			request := elastic.NewBulkDeleteRequest().
+				Index(record.Index).
+				Id(record.ID)
+			if record.Version != nil {
+				request.Version(*record.Version).VersionType(externalVersionType)
+			}
+			bulkRequest.Add(request)
+			continue
+		}
+		if record.OpType == models.OpTypeUpdate {
+			bulkRequest.Add(elastic.NewBulkUpdateRequest().
+				Index(record.Index).
+				Id(record.ID).
+				Doc(record.Json).
+				DocAsUpsert(true).
+				RetryOnConflict(updateRetriesOnConflict))
+			continue
+		}
+		opType := record.OpType
+		if opType == "" {
+			opType = models.OpTypeCreate
+		}
+		request := elastic.NewBulkIndexRequest().OpType(opType).
 			Index(record.Index).
 			Type(record.Type).
 			Id(record.ID).
-			Doc(record.Json))
+			Doc(record.Json)
+		if record.Version != nil {
+			request.Version(*record.Version).VersionType(externalVersionType)
+		}
+		bulkRequest.Add(request)
 	}
 	return bulkRequest, nil
 }
@@ -166,5 +245,6 @@ func NewDatabase(logger log.Logger, config Config, metrics metrics.MetricsPublis
 		metricsPublisher: metrics,
 		logger:           logger,
 		config:           config,
+		dataStreams:      &dataStreams{},
 	}
 }
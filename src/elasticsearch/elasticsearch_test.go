@@ -132,6 +132,59 @@ func TestRecordDatabase_Insert_Multiple(t *testing.T) {
 	db.GetClient().DeleteByQuery(record.Index).Query(elastic.MatchAllQuery{}).Do(context.Background())
 }
 
+func TestRecordDatabase_Insert_ExternalVersionAndDelete(t *testing.T) {
+	record, _ := fixtures.NewElasticRecord()
+	record.OpType = models.OpTypeIndex
+	version := int64(10)
+	record.Version = &version
+	_, err := db.Insert([]*models.ElasticRecord{record})
+	assert.NoError(t, err)
+
+	stale := *record
+	staleVersion := int64(5)
+	stale.Version = &staleVersion
+	stale.Json = map[string]interface{}{"id": -1}
+	_, err = db.Insert([]*models.ElasticRecord{&stale})
+	assert.NoError(t, err)
+	res, err := db.GetClient().Get().Index(record.Index).Id(record.ID).Do(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(10), *res.Version)
+	}
+
+	deleted := *record
+	deleted.OpType = models.OpTypeDelete
+	deletedVersion := int64(11)
+	deleted.Version = &deletedVersion
+	res2, err := db.Insert([]*models.ElasticRecord{&deleted, &deleted})
+	if assert.NoError(t, err) {
+		assert.Empty(t, res2.Retry)
+	}
+	_, err = db.GetClient().Get().Index(record.Index).Id(record.ID).Do(context.Background())
+	assert.True(t, elastic.IsNotFound(err))
+}
+
+func TestRecordDatabase_Insert_Update(t *testing.T) {
+	record, id := fixtures.NewElasticRecord()
+	record.OpType = models.OpTypeUpdate
+	_, err := db.Insert([]*models.ElasticRecord{record})
+	assert.NoError(t, err)
+
+	partial := *record
+	partial.Json = map[string]interface{}{"value": 42}
+	res, err := db.Insert([]*models.ElasticRecord{&partial})
+	if assert.NoError(t, err) {
+		assert.Empty(t, res.AlreadyExists)
+		doc, err := db.GetClient().Get().Index(record.Index).Id(record.ID).Do(context.Background())
+		if assert.NoError(t, err) {
+			var source map[string]interface{}
+			_ = json.Unmarshal(doc.Source, &source)
+			assert.Equal(t, float64(id), source["id"])
+			assert.Equal(t, float64(42), source["value"])
+		}
+	}
+	db.GetClient().DeleteByQuery(record.Index).Query(elastic.MatchAllQuery{}).Do(context.Background())
+}
+
 func setupDB(d RecordDatabase) {
 	templateExists, err := d.GetClient().IndexTemplateExists(config.Index).Do(context.Background())
 	if err != nil {
@@ -0,0 +1,208 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// Placeholders of index name templates.
+const (
+	indexNameTopic = "topic" // {topic}, the record topic
+	indexNameField = "field" // {field:tenant.id}, the value of a record field
+	indexNameTs    = "ts"    // {ts:month}, the bucket of the record timestamp
+	indexNameDate  = "date"  // {date:created_at:month}, the bucket of a record date field
+)
+
+// Time buckets usable instead of a Go time layout in {ts} and {date}.
+var timeBuckets = map[string]TimeIndexSuffix{
+	"hour":  TimeSuffixHour,
+	"day":   TimeSuffixDay,
+	"week":  TimeSuffixWeek,
+	"month": TimeSuffixMonth,
+	"year":  TimeSuffixYear,
+}
+
+// invalidIndexNameChars are replaced in index names, along with uppercase
+// letters which are lowercased.
+var invalidIndexNameChars = strings.NewReplacer(
+	`\`, "_", "/", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_",
+	"|", "_", " ", "_", ",", "_", "#", "_", ":", "_",
+)
+
+// indexName is a parsed index name template, a sequence of literal text and
+// {placeholder} parts.
+type indexName struct {
+	parts []indexNamePart
+}
+
+type indexNamePart struct {
+	literal     string
+	placeholder string
+	field       string
+	layout      string
+}
+
+func parseIndexName(template string) (*indexName, error) {
+	name := &indexName{}
+	for rest := template; rest != ""; {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			start = len(rest)
+		}
+		if strings.Contains(rest[:start], "}") {
+			return nil, fmt.Errorf("unexpected } in index name template %q", template)
+		}
+		if start > 0 {
+			name.parts = append(name.parts, indexNamePart{literal: rest[:start]})
+		}
+		if start == len(rest) {
+			break
+		}
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in index name template %q", template)
+		}
+		part, err := parsePlaceholder(rest[start+1 : end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid index name template %q: %w", template, err)
+		}
+		name.parts = append(name.parts, part)
+		rest = rest[end+1:]
+	}
+	return name, nil
+}
+
+func parsePlaceholder(placeholder string) (indexNamePart, error) {
+	args := strings.Split(placeholder, ":")
+	part := indexNamePart{placeholder: args[0]}
+	switch {
+	case args[0] == indexNameTopic && len(args) == 1:
+	case args[0] == indexNameField && len(args) == 2 && args[1] != "":
+		part.field = args[1]
+	case args[0] == indexNameTs && len(args) >= 2:
+		// Go layouts may contain colons, e.g. 15:04
+		part.layout = strings.Join(args[1:], ":")
+	case args[0] == indexNameDate && len(args) >= 3 && args[1] != "":
+		part.field = args[1]
+		part.layout = strings.Join(args[2:], ":")
+	default:
+		return part, fmt.Errorf("unknown placeholder {%s}", placeholder)
+	}
+	return part, nil
+}
+
+// render returns the index name of a record.
+func (n *indexName) render(record *models.Record) (string, error) {
+	var b strings.Builder
+	for _, part := range n.parts {
+		switch part.placeholder {
+		case "":
+			b.WriteString(part.literal)
+		case indexNameTopic:
+			b.WriteString(record.Topic)
+		case indexNameField:
+			value, err := record.GetValueForField(part.field)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+		case indexNameTs:
+			b.WriteString(formatTime(record.Timestamp, part.layout))
+		case indexNameDate:
+			t, err := fieldTime(record, part.field)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(formatTime(t, part.layout))
+		}
+	}
+	return b.String(), nil
+}
+
+// pattern returns the index pattern matching every index a topic is written
+// to, with placeholders other than {topic} as wildcards.
+func (n *indexName) pattern(topic string) string {
+	var b strings.Builder
+	for _, part := range n.parts {
+		switch part.placeholder {
+		case "":
+			b.WriteString(sanitizeIndexName(part.literal))
+		case indexNameTopic:
+			b.WriteString(sanitizeIndexName(topic))
+		default:
+			if !strings.HasSuffix(b.String(), "*") {
+				b.WriteString("*")
+			}
+		}
+	}
+	return b.String()
+}
+
+// sanitizeIndexName lowercases a name and replaces characters Elasticsearch
+// doesn't allow in index names.
+func sanitizeIndexName(name string) string {
+	return invalidIndexNameChars.Replace(strings.ToLower(name))
+}
+
+// formatTime formats t as a time bucket, e.g. "month", or with a Go layout.
+func formatTime(t time.Time, layout string) string {
+	if bucket, ok := timeBuckets[layout]; ok {
+		return formatTimeBucket(t, bucket)
+	}
+	return t.Format(layout)
+}
+
+func formatTimeBucket(t time.Time, bucket TimeIndexSuffix) string {
+	switch bucket {
+	case TimeSuffixHour:
+		return t.Format("2006-01-02-15")
+	case TimeSuffixWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-w%02d", year, week)
+	case TimeSuffixMonth:
+		return t.Format("2006-01")
+	case TimeSuffixYear:
+		return t.Format("2006")
+	}
+	return t.Format("2006-01-02")
+}
+
+// fieldTime reads a date from a record field, either an ISO-8601 date or
+// timestamp or a number of milliseconds since the epoch.
+func fieldTime(record *models.Record, field string) (time.Time, error) {
+	value, ok := record.LookupField(field)
+	if !ok {
+		return time.Time{}, fmt.Errorf("could not get date from column %s", field)
+	}
+	var millis int64
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("value from column %s is not a date", field)
+	case int32:
+		millis = int64(v)
+	case int64:
+		millis = v
+	case float64:
+		millis = int64(v)
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("value from column %s is not a date", field)
+		}
+		millis = n
+	default:
+		return time.Time{}, fmt.Errorf("value from column %s is not a date", field)
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC(), nil
+}
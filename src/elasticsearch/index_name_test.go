@@ -0,0 +1,97 @@
+package elasticsearch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+func TestParseIndexName_Invalid(t *testing.T) {
+	for _, template := range []string{
+		"logs-{topic",
+		"logs-topic}",
+		"logs-{unknown}",
+		"logs-{field}",
+		"logs-{ts}",
+		"logs-{date:created_at}",
+		"logs-{topic:name}",
+	} {
+		_, err := parseIndexName(template)
+		assert.Error(t, err, template)
+	}
+}
+
+func TestIndexName_Render(t *testing.T) {
+	record := &models.Record{
+		Topic:     "My-Topic",
+		Timestamp: time.Date(2021, 1, 7, 10, 0, 0, 0, time.UTC),
+		Json: map[string]interface{}{
+			"tenant":     map[string]interface{}{"id": "ACME Corp"},
+			"region":     "us/east",
+			"created_at": "2020-12-31T23:00:00-03:00",
+			"paid_at":    int64(1590969600000),
+		},
+	}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"logs-{topic}-{field:tenant.id}-{ts:2006.01}", "logs-My-Topic-ACME Corp-2021.01"},
+		{"{topic}-{field:region}", "My-Topic-us/east"},
+		{"{topic}-{ts:hour}", "My-Topic-2021-01-07-10"},
+		{"{topic}-{ts:day}", "My-Topic-2021-01-07"},
+		{"{topic}-{ts:week}", "My-Topic-2021-w01"},
+		{"{topic}-{ts:month}", "My-Topic-2021-01"},
+		{"{topic}-{ts:year}", "My-Topic-2021"},
+		{"{topic}-{ts:2006.01.02-15:04}", "My-Topic-2021.01.07-10:00"},
+		{"{topic}-{date:created_at:month}", "My-Topic-2021-01"},
+		{"{topic}-{date:paid_at:day}", "My-Topic-2020-06-01"},
+	}
+	for _, tt := range tests {
+		name, err := parseIndexName(tt.template)
+		if assert.NoError(t, err) {
+			rendered, err := name.render(record)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, rendered, tt.template)
+			}
+		}
+	}
+}
+
+func TestIndexName_RenderInexistentField(t *testing.T) {
+	record := &models.Record{Topic: "my-topic", Json: map[string]interface{}{"created_at": true}}
+	for _, template := range []string{"{field:tenant.id}", "{date:paid_at:day}", "{date:created_at:day}"} {
+		name, err := parseIndexName(template)
+		if assert.NoError(t, err) {
+			_, err := name.render(record)
+			assert.Error(t, err, template)
+		}
+	}
+}
+
+func TestIndexName_Pattern(t *testing.T) {
+	name, err := parseIndexName("Logs-{topic}-{field:tenant.id}{ts:month}")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "logs-my_topic-*", name.pattern("My Topic"))
+	}
+}
+
+func TestCodec_EncodeElasticRecords_IndexNameTemplate(t *testing.T) {
+	codec := NewCodec(codecLogger, Config{
+		IndexPrefix:       "_Prefix-",
+		IndexNameTemplate: "{topic}-{field:tenant.id}-{ts:year}",
+	})
+	record := &models.Record{
+		Topic:     "events",
+		Timestamp: time.Date(2021, 1, 7, 10, 0, 0, 0, time.UTC),
+		Json:      map[string]interface{}{"tenant": map[string]interface{}{"id": "Tenant #1"}},
+	}
+
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) && assert.Len(t, elasticRecords, 1) {
+		assert.Equal(t, "prefix-events-tenant__1-2021", elasticRecords[0].Index)
+	}
+}
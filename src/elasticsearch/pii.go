@@ -0,0 +1,208 @@
+package elasticsearch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+	maskRune      = '*'
+)
+
+// piiMasker hides personal data in documents: fields are redacted, partially
+// masked, or replaced by their salted hash, so they can still be grouped by.
+type piiMasker struct {
+	redact   []models.FieldPath
+	mask     []models.FieldPath
+	hash     []models.FieldPath
+	salt     []byte
+	keepLast int
+}
+
+// newPIIMasker returns nil when no field is configured to be masked. The hash
+// salt is read from PIIHashSaltFile, or else PIIHashSalt, and is required to
+// hash fields, since unsalted hashes of emails or phone numbers are easily
+// reversed.
+func newPIIMasker(config Config) (*piiMasker, error) {
+	m := &piiMasker{keepLast: config.PIIMaskKeepLast}
+	var err error
+	if m.redact, err = parseFieldList(config.PIIRedactFields); err != nil {
+		return nil, err
+	}
+	if m.mask, err = parseFieldList(config.PIIMaskFields); err != nil {
+		return nil, err
+	}
+	if m.hash, err = parseFieldList(config.PIIHashFields); err != nil {
+		return nil, err
+	}
+	if len(m.redact)+len(m.mask)+len(m.hash) == 0 {
+		return nil, nil
+	}
+	if len(m.hash) > 0 {
+		salt := config.PIIHashSalt
+		if config.PIIHashSaltFile != "" {
+			content, err := ioutil.ReadFile(config.PIIHashSaltFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read hash salt file: %w", err)
+			}
+			salt = strings.TrimRight(string(content), "\r\n")
+		}
+		if salt == "" {
+			return nil, errors.New("a hash salt is required to hash fields")
+		}
+		m.salt = []byte(salt)
+	}
+	return m, nil
+}
+
+// checkPIINotInNames fails when personal data fields, or fields nested in
+// them, are used in index names or in document IDs that aren't hashed, since
+// these are built from the original record fields.
+func checkPIINotInNames(config Config) error {
+	var masked []models.FieldPath
+	for _, fields := range [][]string{config.PIIRedactFields, config.PIIMaskFields, config.PIIHashFields} {
+		paths, err := parseFieldList(fields)
+		if err != nil {
+			return err
+		}
+		masked = append(masked, paths...)
+	}
+	if len(masked) == 0 {
+		return nil
+	}
+
+	// the fields used by each setting, invalid templates are reported when
+	// the codec parses them
+	used := make(map[string][]string)
+	if config.DocIDHash == "" {
+		if config.DocIDColumn != "" {
+			used["ES_DOC_ID_COLUMN"] = []string{config.DocIDColumn}
+		}
+		if template, err := parseDocIDTemplate(config.DocIDTemplate); err == nil {
+			for _, part := range template.parts {
+				if part.field != "" {
+					used["ES_DOC_ID_TEMPLATE"] = append(used["ES_DOC_ID_TEMPLATE"], part.field)
+				}
+			}
+		}
+	}
+	if config.IndexColumn != "" {
+		used["ES_INDEX_COLUMN"] = []string{config.IndexColumn}
+	}
+	if name, err := parseIndexName(config.IndexNameTemplate); err == nil {
+		for _, part := range name.parts {
+			if part.field != "" {
+				used["ES_INDEX_NAME_TEMPLATE"] = append(used["ES_INDEX_NAME_TEMPLATE"], part.field)
+			}
+		}
+	}
+
+	for setting, fields := range used {
+		for _, field := range fields {
+			path, err := models.ParseFieldPath(field)
+			if err != nil {
+				continue
+			}
+			for _, pii := range masked {
+				if path.Overlaps(pii) {
+					return fmt.Errorf("personal data field %s would be written unmasked by %s field %s", pii, setting, field)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parseFieldList(fields []string) ([]models.FieldPath, error) {
+	var paths []models.FieldPath
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		path, err := models.ParseFieldPath(field)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func (m *piiMasker) apply(doc map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return doc
+	}
+	replace(doc, m.redact, func(string) string { return redactedValue })
+	replace(doc, m.mask, m.maskValue)
+	replace(doc, m.hash, m.hashValue)
+	return doc
+}
+
+// replace replaces the values at paths, and every element of arrays at paths,
+// by the result of f on the values formatted as strings. Null values are kept.
+func replace(doc map[string]interface{}, paths []models.FieldPath, f func(string) string) {
+	for _, path := range paths {
+		value, ok := path.Lookup(doc)
+		if !ok || value == nil {
+			continue
+		}
+		if values, ok := value.([]interface{}); ok {
+			replaced := make([]interface{}, len(values))
+			for i, v := range values {
+				if v != nil {
+					replaced[i] = f(formatPIIValue(v))
+				}
+			}
+			path.Set(doc, replaced)
+			continue
+		}
+		path.Set(doc, f(formatPIIValue(value)))
+	}
+}
+
+func formatPIIValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// maskValue masks all but the last keepLast characters of value, e.g.
+// "*******4321", or all of them in values that short. Emails keep their first
+// character and domain instead, e.g. "j***@example.com".
+func (m *piiMasker) maskValue(value string) string {
+	if at := strings.LastIndex(value, "@"); at > 0 {
+		local := []rune(value[:at])
+		return string(local[0]) + strings.Repeat(string(maskRune), len(local)-1) + value[at:]
+	}
+	runes := []rune(value)
+	masked := len(runes) - m.keepLast
+	if masked <= 0 || m.keepLast < 0 {
+		masked = len(runes)
+	}
+	for i := 0; i < masked; i++ {
+		runes[i] = maskRune
+	}
+	return string(runes)
+}
+
+// hashValue returns the hex encoded HMAC-SHA256 of value keyed by the salt.
+func (m *piiMasker) hashValue(value string) string {
+	mac := hmac.New(sha256.New, m.salt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
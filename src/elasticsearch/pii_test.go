@@ -0,0 +1,94 @@
+package elasticsearch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+func hmacHex(salt, value string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPIIMasking(t *testing.T) {
+	codec := NewCodec(log.NewNopLogger(), Config{
+		PIIRedactFields: []string{"user.password", "missing", "nickname"},
+		PIIMaskFields:   []string{"email", "emails", "user.address.zip"},
+		PIIMaskKeepLast: 4,
+		PIIHashFields:   []string{"user.phone", ""},
+		PIIHashSalt:     "pepper",
+		Transforms:      "copy: email -> email_copy",
+	})
+	record := userRecord()
+	elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if !assert.Empty(t, rejected) {
+		return
+	}
+	doc := elasticRecords[0].Json
+	assert.Equal(t, "j***@example.com", doc["email"])
+	assert.Equal(t, []interface{}{"a*@b.com", nil}, doc["emails"])
+	// transforms run after masking, so they can't copy the original value
+	assert.Equal(t, "j***@example.com", doc["email_copy"])
+	assert.Equal(t, nil, doc["nickname"])
+	assert.NotContains(t, doc, "missing")
+	assert.Equal(t, map[string]interface{}{
+		"name":     "alice",
+		"password": redactedValue,
+		"phone":    hmacHex("pepper", "5581987654321"),
+		"address":  map[string]interface{}{"city": "Recife", "zip": "*0000"},
+	}, doc["user"])
+
+	// nested objects are masked in copies, the decoded values stay readable
+	user := record.Json["user"].(map[string]interface{})
+	assert.Equal(t, "secret", user["password"])
+	assert.Equal(t, "50000", user["address"].(map[string]interface{})["zip"])
+	assert.Equal(t, []interface{}{"ab@b.com", nil}, record.Json["emails"])
+}
+
+func TestPIIMasker_MaskValue(t *testing.T) {
+	m := &piiMasker{keepLast: 2}
+	assert.Equal(t, "****56", m.maskValue("123456"))
+	assert.Equal(t, "**", m.maskValue("12"))
+	assert.Equal(t, "*", m.maskValue("1"))
+	assert.Equal(t, "ü*******@exämple.com", m.maskValue("üser.nam@exämple.com"))
+}
+
+func TestNewPIIMasker_SaltFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "salt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from-file\n")
+	file.Close()
+
+	m, err := newPIIMasker(Config{PIIHashFields: []string{"email"}, PIIHashSalt: "from-env", PIIHashSaltFile: file.Name()})
+	if assert.NoError(t, err) {
+		assert.Equal(t, hmacHex("from-file", "a@b.com"), m.hashValue("a@b.com"))
+	}
+}
+
+func TestNewPIIMasker_Invalid(t *testing.T) {
+	m, err := newPIIMasker(Config{PIIRedactFields: []string{""}})
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	_, err = newPIIMasker(Config{PIIHashFields: []string{"email"}})
+	assert.Error(t, err)
+
+	_, err = newPIIMasker(Config{PIIHashFields: []string{"email"}, PIIHashSaltFile: "/nonexistent/salt"})
+	assert.Error(t, err)
+
+	_, err = newPIIMasker(Config{PIIMaskFields: []string{"a..b"}})
+	assert.Error(t, err)
+}
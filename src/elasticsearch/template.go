@@ -0,0 +1,81 @@
+package elasticsearch
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/olivere/elastic/v7"
+)
+
+// templatePriority is above the priority of the built-in templates, e.g.
+// logs-*-*, so the injector templates win for indexes matching both.
+const templatePriority = 200
+
+// IndexTemplateName is the name of the index template of the indexes a topic
+// is written to.
+func IndexTemplateName(config Config, topic string) string {
+	name := config.Index
+	if name == "" {
+		name = topic
+	}
+	return config.IndexPrefix + name
+}
+
+// IndexTemplate returns the composable index template applying mappings to
+// every index a topic is written to.
+func IndexTemplate(config Config, topic string, mappings map[string]interface{}) (map[string]interface{}, error) {
+	name := IndexTemplateName(config, topic)
+	pattern := name + "-*"
+	switch {
+	case config.IndexNameTemplate != "":
+		indexName, err := parseIndexName(config.IndexNameTemplate)
+		if err != nil {
+			return nil, err
+		}
+		pattern = strings.TrimLeft(sanitizeIndexName(config.IndexPrefix)+indexName.pattern(topic), "-_+")
+	case config.DataStream, config.TimeSuffix == TimeSuffixNone && config.IndexColumn == "":
+		pattern = name
+	}
+	return indexTemplate(config, pattern, mappings), nil
+}
+
+func indexTemplate(config Config, pattern string, mappings map[string]interface{}) map[string]interface{} {
+	settings := map[string]interface{}{}
+	template := map[string]interface{}{
+		"index_patterns": []string{pattern},
+		"priority":       templatePriority,
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": mappings,
+		},
+	}
+	if config.DataStream {
+		template["data_stream"] = map[string]interface{}{}
+		if config.DataStreamBootstrap {
+			settings["index.lifecycle.name"] = config.ILMPolicy
+		}
+	}
+	return template
+}
+
+// PutIndexTemplate creates a composable index template, unless one with the
+// same name exists. Existing templates are never overwritten, so they can be
+// managed outside of the injector.
+func (d recordDatabase) PutIndexTemplate(name string, template map[string]interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.BulkTimeout)
+	defer cancel()
+
+	_, err := d.GetClient().IndexGetIndexTemplate(name).Do(ctx)
+	if err == nil {
+		return false, nil
+	}
+	if !elastic.IsNotFound(err) {
+		return false, err
+	}
+	level.Info(d.logger).Log("message", "creating index template", "template", name)
+	if _, err := d.GetClient().IndexPutIndexTemplate(name).BodyJson(template).Do(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,44 @@
+package elasticsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexTemplate(t *testing.T) {
+	mappings := map[string]interface{}{"properties": map[string]interface{}{}}
+	tests := []struct {
+		config   Config
+		name     string
+		patterns []string
+	}{
+		{Config{}, "my-topic", []string{"my-topic-*"}},
+		{Config{Index: "events", IndexPrefix: "prefix-"}, "prefix-events", []string{"prefix-events-*"}},
+		{Config{TimeSuffix: TimeSuffixNone}, "my-topic", []string{"my-topic"}},
+		{Config{TimeSuffix: TimeSuffixNone, IndexColumn: "id"}, "my-topic", []string{"my-topic-*"}},
+		{Config{DataStream: true}, "my-topic", []string{"my-topic"}},
+		{Config{IndexPrefix: "Prefix-", IndexNameTemplate: "logs-{topic}-{field:tenant.id}-{ts:month}"}, "Prefix-my-topic", []string{"prefix-logs-my-topic-*-*"}},
+		{Config{IndexNameTemplate: "{topic}"}, "my-topic", []string{"my-topic"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.name, IndexTemplateName(tt.config, "my-topic"))
+		template, err := IndexTemplate(tt.config, "my-topic", mappings)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, tt.patterns, template["index_patterns"])
+		assert.Equal(t, mappings, template["template"].(map[string]interface{})["mappings"])
+		_, isDataStream := template["data_stream"]
+		assert.Equal(t, tt.config.DataStream, isDataStream)
+	}
+}
+
+func TestIndexTemplate_DataStreamLifecycle(t *testing.T) {
+	config := Config{DataStream: true, DataStreamBootstrap: true, ILMPolicy: "policy"}
+
+	template, err := IndexTemplate(config, "my-topic", map[string]interface{}{})
+	assert.NoError(t, err)
+	settings := template["template"].(map[string]interface{})["settings"].(map[string]interface{})
+	assert.Equal(t, "policy", settings["index.lifecycle.name"])
+}
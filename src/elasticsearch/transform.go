@@ -0,0 +1,214 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// Operations of document transforms.
+const (
+	TransformInclude = "include"
+	TransformDrop    = "drop"
+	TransformRename  = "rename"
+	TransformMove    = "move"
+	TransformCopy    = "copy"
+	TransformSet     = "set"
+)
+
+// transform changes the document built from a record. The document is a copy
+// of the record fields, but nested values are shared with the record, so
+// transforms must copy them before changing them, as models.FieldPath does.
+type transform func(doc map[string]interface{}) map[string]interface{}
+
+// transformChain is a parsed list of transforms, applied in order, e.g.
+// "include: id, user; rename: user.name -> user_name; set: source = \"kafka\"".
+type transformChain []transform
+
+func parseTransforms(chain string) (transformChain, error) {
+	var transforms transformChain
+	for _, step := range splitTopLevel(chain, ';') {
+		if step == "" {
+			continue
+		}
+		colon := strings.Index(step, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("missing operation in transform %q", step)
+		}
+		op := strings.TrimSpace(step[:colon])
+		var args []string
+		for _, arg := range splitTopLevel(step[colon+1:], ',') {
+			if arg != "" {
+				args = append(args, arg)
+			}
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing fields in transform %q", step)
+		}
+		t, err := parseTransform(op, args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform %q: %w", step, err)
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+func parseTransform(op string, args []string) (transform, error) {
+	switch op {
+	case TransformInclude:
+		paths, err := parseFieldPaths(args)
+		if err != nil {
+			return nil, err
+		}
+		return includeTransform(paths), nil
+	case TransformDrop:
+		paths, err := parseFieldPaths(args)
+		if err != nil {
+			return nil, err
+		}
+		return dropTransform(paths), nil
+	case TransformRename, TransformMove, TransformCopy:
+		var sources, targets []models.FieldPath
+		for _, arg := range args {
+			parts := strings.SplitN(arg, "->", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("expected source -> target, got %q", arg)
+			}
+			paths, err := parseFieldPaths(parts)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, paths[0])
+			targets = append(targets, paths[1])
+		}
+		return moveTransform(sources, targets, op != TransformCopy), nil
+	case TransformSet:
+		var paths []models.FieldPath
+		var values []interface{}
+		for _, arg := range args {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("expected field = value, got %q", arg)
+			}
+			path, err := models.ParseFieldPath(strings.TrimSpace(parts[0]))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+			values = append(values, parseConstant(strings.TrimSpace(parts[1])))
+		}
+		return setTransform(paths, values), nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", op)
+}
+
+func parseFieldPaths(args []string) ([]models.FieldPath, error) {
+	paths := make([]models.FieldPath, len(args))
+	for i, arg := range args {
+		path, err := models.ParseFieldPath(strings.TrimSpace(arg))
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+// parseConstant parses JSON values, e.g. "kafka", 2 or {"a": true}, and takes
+// anything else as a plain string.
+func parseConstant(value string) interface{} {
+	var constant interface{}
+	if err := json.Unmarshal([]byte(value), &constant); err != nil {
+		return value
+	}
+	return constant
+}
+
+func (t transformChain) apply(doc map[string]interface{}) map[string]interface{} {
+	for _, transform := range t {
+		doc = transform(doc)
+	}
+	return doc
+}
+
+// includeTransform keeps only the fields at paths, at the same paths.
+func includeTransform(paths []models.FieldPath) transform {
+	return func(doc map[string]interface{}) map[string]interface{} {
+		included := make(map[string]interface{}, len(paths))
+		for _, path := range paths {
+			if value, ok := path.Lookup(doc); ok {
+				path.Set(included, value)
+			}
+		}
+		return included
+	}
+}
+
+func dropTransform(paths []models.FieldPath) transform {
+	return func(doc map[string]interface{}) map[string]interface{} {
+		for _, path := range paths {
+			path.Remove(doc)
+		}
+		return doc
+	}
+}
+
+// moveTransform moves or copies the fields at sources to targets. Missing
+// sources are skipped, and sources are kept when their target can't be set.
+func moveTransform(sources, targets []models.FieldPath, remove bool) transform {
+	return func(doc map[string]interface{}) map[string]interface{} {
+		for i, source := range sources {
+			value, ok := source.Lookup(doc)
+			if !ok {
+				continue
+			}
+			if remove {
+				source.Remove(doc)
+			}
+			if !targets[i].Set(doc, value) && remove {
+				source.Set(doc, value)
+			}
+		}
+		return doc
+	}
+}
+
+func setTransform(paths []models.FieldPath, values []interface{}) transform {
+	return func(doc map[string]interface{}) map[string]interface{} {
+		for i, path := range paths {
+			path.Set(doc, values[i])
+		}
+		return doc
+	}
+}
+
+// splitTopLevel splits s on sep outside of quotes, brackets and braces, so
+// constants and quoted keys can contain separators, and trims the parts.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,98 @@
+package elasticsearch
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+func TestParseTransforms(t *testing.T) {
+	chain, err := parseTransforms(`include: id, user; rename: user.name -> user_name; set: source = "kafka; test", tags = ["a", "b"];`)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 3)
+}
+
+func TestParseTransforms_Invalid(t *testing.T) {
+	for _, transforms := range []string{"id", "include:", "rename: a", "set: a", "upper: a", "drop: a..b"} {
+		_, err := parseTransforms(transforms)
+		assert.Error(t, err, transforms)
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		transforms string
+		expected   map[string]interface{}
+	}{
+		{
+			"include: id, user.address.city",
+			map[string]interface{}{
+				"id":   "42",
+				"user": map[string]interface{}{"address": map[string]interface{}{"city": "Recife"}},
+			},
+		},
+		{
+			"drop: email, emails, nickname, user.password, user.address, items[0].price; drop: items[0].sku",
+			map[string]interface{}{
+				"id":    "42",
+				"user":  map[string]interface{}{"name": "alice", "phone": int64(5581987654321)},
+				"items": []interface{}{map[string]interface{}{}},
+			},
+		},
+		{
+			"include: id, user.name; rename: user.name -> user_name",
+			map[string]interface{}{"id": "42", "user": map[string]interface{}{}, "user_name": "alice"},
+		},
+		{
+			"include: id, user.address; move: user.address -> address; copy: id -> meta.id",
+			map[string]interface{}{
+				"id":      "42",
+				"user":    map[string]interface{}{},
+				"address": map[string]interface{}{"city": "Recife", "zip": "50000"},
+				"meta":    map[string]interface{}{"id": "42"},
+			},
+		},
+		{
+			"include: id, user.name; move: missing -> other, id -> user.name.first",
+			map[string]interface{}{"id": "42", "user": map[string]interface{}{"name": "alice"}},
+		},
+		{
+			`include: id; set: source = kafka, meta.version = 2, meta['a.b'] = {"c": [true]}, id = "x,y"`,
+			map[string]interface{}{
+				"id":     "x,y",
+				"source": "kafka",
+				"meta":   map[string]interface{}{"version": 2.0, "a.b": map[string]interface{}{"c": []interface{}{true}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		codec := NewCodec(log.NewNopLogger(), Config{Transforms: tt.transforms})
+		record := userRecord()
+		elasticRecords, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+		if assert.Empty(t, rejected, tt.transforms) {
+			assert.Equal(t, tt.expected, elasticRecords[0].Json, tt.transforms)
+		}
+	}
+}
+
+func TestTransforms_CopyNestedValues(t *testing.T) {
+	codec := NewCodec(log.NewNopLogger(), Config{
+		Transforms: "drop: user.password, items[0].price; move: user.address -> address; set: user.name = bob",
+	})
+	record := userRecord()
+	user := record.Json["user"].(map[string]interface{})
+	item := record.Json["items"].([]interface{})[0].(map[string]interface{})
+
+	_, rejected := codec.EncodeElasticRecords([]*models.Record{record})
+	if assert.Empty(t, rejected) {
+		// dropped, moved and set fields change copies of the objects shared
+		// with the decoded record
+		assert.Equal(t, "secret", user["password"])
+		assert.Equal(t, "alice", user["name"])
+		assert.Contains(t, user, "address")
+		assert.Equal(t, 10.0, item["price"])
+	}
+}
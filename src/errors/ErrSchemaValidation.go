@@ -0,0 +1,5 @@
+package errors
+
+import "errors"
+
+var ErrSchemaValidation = errors.New("record does not match its schema")
@@ -0,0 +1,5 @@
+package errors
+
+import "errors"
+
+var ErrSkippedMessage = errors.New("message is not meant to be indexed")
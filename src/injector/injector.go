@@ -1,17 +1,20 @@
 package injector
 
 import (
+	"fmt"
+	"regexp"
 	"strconv"
 
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/deadletter"
 	"github.com/inloco/kafka-elasticsearch-injector/src/kafka"
 	"github.com/inloco/kafka-elasticsearch-injector/src/schema_registry"
 )
 
-func MakeKafkaConsumer(endpoints Endpoints, logger log.Logger, schemaRegistry *schema_registry.SchemaRegistry, kafkaConfig *kafka.Config) (kafka.Consumer, error) {
+func MakeKafkaConsumer(endpoints Endpoints, logger log.Logger, schemaRegistry *schema_registry.SchemaRegistry, kafkaConfig *kafka.Config, deadLetter deadletter.Publisher) (kafka.Consumer, error) {
 	concurrency, err := strconv.Atoi(kafkaConfig.Concurrency)
 	if err != nil {
 		level.Warn(logger).Log("err", err, "message", "failed to get consumer concurrency")
@@ -28,13 +31,112 @@ func MakeKafkaConsumer(endpoints Endpoints, logger log.Logger, schemaRegistry *s
 		metricsUpdateInterval = 30 * time.Second
 	}
 
+	shutdownTimeout, err := time.ParseDuration(kafkaConfig.ShutdownTimeout)
+	if err != nil {
+		level.Warn(logger).Log("err", err, "message", "failed to get consumer shutdown timeout")
+		shutdownTimeout = 20 * time.Second
+	}
+
+	var topicsPattern *regexp.Regexp
+	if kafkaConfig.TopicsPattern != "" {
+		topicsPattern, err = regexp.Compile(kafkaConfig.TopicsPattern)
+		if err != nil {
+			return kafka.Consumer{}, err
+		}
+	}
+	topicsRefreshInterval, err := time.ParseDuration(kafkaConfig.TopicsRefreshInterval)
+	if err != nil {
+		if topicsPattern != nil {
+			level.Warn(logger).Log("err", err, "message", "failed to get topics refresh interval")
+		}
+		topicsRefreshInterval = 1 * time.Minute
+	}
+
 	bufferSize, err := strconv.Atoi(kafkaConfig.BufferSize)
 	if err != nil {
 		bufferSize = batchSize * concurrency
 	}
 
+	var validateSchema bool
+	if kafkaConfig.ValidateSchema != "" {
+		validateSchema, err = strconv.ParseBool(kafkaConfig.ValidateSchema)
+		if err != nil {
+			return kafka.Consumer{}, err
+		}
+	}
+
+	switch kafkaConfig.DecimalFormat {
+	case "", kafka.DecimalFormatString, kafka.DecimalFormatDouble:
+	default:
+		return kafka.Consumer{}, fmt.Errorf("unknown avro decimal format %q", kafkaConfig.DecimalFormat)
+	}
+	switch kafkaConfig.TimestampFormat {
+	case "", kafka.TimestampFormatISO8601, kafka.TimestampFormatEpochMillis:
+	default:
+		return kafka.Consumer{}, fmt.Errorf("unknown avro timestamp format %q", kafkaConfig.TimestampFormat)
+	}
+
+	switch kafkaConfig.KeyFormat {
+	case "", kafka.KeyFormatString, kafka.KeyFormatBytesBase64, kafka.KeyFormatAvro, kafka.KeyFormatJson, kafka.KeyFormatLong:
+	default:
+		return kafka.Consumer{}, fmt.Errorf("unknown key format %q", kafkaConfig.KeyFormat)
+	}
+
+	var metadataField string
+	var metadataHeaders []string
+	if kafkaConfig.IncludeMetadata != "" {
+		includeMetadata, err := strconv.ParseBool(kafkaConfig.IncludeMetadata)
+		if err != nil {
+			return kafka.Consumer{}, err
+		}
+		if includeMetadata {
+			metadataField = kafkaConfig.MetadataField
+			if metadataField == "" {
+				metadataField = "kafka"
+			}
+			for _, header := range kafkaConfig.MetadataHeaders {
+				if header != "" {
+					metadataHeaders = append(metadataHeaders, header)
+				}
+			}
+		}
+	}
+
+	var deleteOnTombstone bool
+	if kafkaConfig.DeleteOnTombstone != "" {
+		deleteOnTombstone, err = strconv.ParseBool(kafkaConfig.DeleteOnTombstone)
+		if err != nil {
+			return kafka.Consumer{}, err
+		}
+	}
+
 	deserializer := &kafka.Decoder{
-		SchemaRegistry: schemaRegistry,
+		SchemaRegistry:    schemaRegistry,
+		ValidateSchema:    validateSchema,
+		DecimalFormat:     kafkaConfig.DecimalFormat,
+		TimestampFormat:   kafkaConfig.TimestampFormat,
+		KeyFormat:         kafkaConfig.KeyFormat,
+		MetadataField:     metadataField,
+		MetadataHeaders:   metadataHeaders,
+		DeleteOnTombstone: deleteOnTombstone,
+	}
+
+	var decoder kafka.DecodeMessageFunc
+	switch kafkaConfig.CDCMode {
+	case "":
+		decoder = deserializer.DeserializerFor(kafkaConfig.RecordType)
+	case kafka.CDCModeDebezium:
+		decoder = deserializer.DebeziumDeserializerFor(kafkaConfig.RecordType)
+	default:
+		return kafka.Consumer{}, fmt.Errorf("unknown cdc mode %q", kafkaConfig.CDCMode)
+	}
+
+	var filter *kafka.Filter
+	if kafkaConfig.Filter != "" {
+		filter, err = kafka.ParseFilter(kafkaConfig.Filter)
+		if err != nil {
+			return kafka.Consumer{}, err
+		}
 	}
 
 	includeKey, err := strconv.ParseBool(kafkaConfig.IncludeKey)
@@ -46,16 +148,31 @@ func MakeKafkaConsumer(endpoints Endpoints, logger log.Logger, schemaRegistry *s
 		includeKey = false
 	}
 
+	var flushInterval time.Duration
+	if kafkaConfig.FlushInterval != "" {
+		flushInterval, err = time.ParseDuration(kafkaConfig.FlushInterval)
+		if err != nil {
+			level.Warn(logger).Log("err", err, "message", "failed to get consumer flush interval")
+			flushInterval = 0
+		}
+	}
+
 	return kafka.Consumer{
 		Topics:                kafkaConfig.Topics,
+		TopicsPattern:         topicsPattern,
+		TopicsRefreshInterval: topicsRefreshInterval,
 		Group:                 kafkaConfig.ConsumerGroup,
 		Endpoint:              endpoints.Insert(),
-		Decoder:               deserializer.DeserializerFor(kafkaConfig.RecordType),
+		Decoder:               decoder,
 		Logger:                logger,
 		Concurrency:           concurrency,
 		BatchSize:             batchSize,
 		MetricsUpdateInterval: metricsUpdateInterval,
 		BufferSize:            bufferSize,
 		IncludeKey:            includeKey,
+		FlushInterval:         flushInterval,
+		DeadLetter:            deadLetter,
+		Filter:                filter,
+		ShutdownTimeout:       shutdownTimeout,
 	}, nil
 }
@@ -22,3 +22,7 @@ func (s instrumentingMiddleware) Insert(records []*models.Record) error {
 func (s instrumentingMiddleware) ReadinessCheck() bool {
 	return s.next.ReadinessCheck()
 }
+
+func (s instrumentingMiddleware) Close() {
+	s.next.Close()
+}
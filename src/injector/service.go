@@ -2,6 +2,7 @@ package injector
 
 import (
 	"github.com/go-kit/kit/log"
+	"github.com/inloco/kafka-elasticsearch-injector/src/deadletter"
 	"github.com/inloco/kafka-elasticsearch-injector/src/injector/store"
 	"github.com/inloco/kafka-elasticsearch-injector/src/metrics"
 	"github.com/inloco/kafka-elasticsearch-injector/src/models"
@@ -10,6 +11,7 @@ import (
 type Service interface {
 	Insert(records []*models.Record) error
 	ReadinessCheck() bool
+	Close()
 }
 
 type basicService struct {
@@ -24,11 +26,15 @@ func (s basicService) ReadinessCheck() bool {
 	return s.store.ReadinessCheck()
 }
 
-func NewService(logger log.Logger, metrics metrics.MetricsPublisher) Service {
+func (s basicService) Close() {
+	s.store.Close()
+}
+
+func NewService(logger log.Logger, metrics metrics.MetricsPublisher, deadLetter deadletter.Publisher) Service {
 	return instrumentingMiddleware{
 		metricsPublisher: metrics,
 		next: basicService{
-			store.NewStore(logger, metrics),
+			store.NewStore(logger, metrics, deadLetter),
 		},
 	}
 }
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"errors"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/deadletter"
 	"github.com/inloco/kafka-elasticsearch-injector/src/elasticsearch"
 	"github.com/inloco/kafka-elasticsearch-injector/src/metrics"
 	"github.com/inloco/kafka-elasticsearch-injector/src/models"
@@ -12,12 +15,15 @@ import (
 type Store interface {
 	Insert(records []*models.Record) error
 	ReadinessCheck() bool
+	Close()
 }
 
 type basicStore struct {
-	db      elasticsearch.RecordDatabase
-	codec   elasticsearch.Codec
-	backoff time.Duration
+	db         elasticsearch.RecordDatabase
+	codec      elasticsearch.Codec
+	backoff    time.Duration
+	deadLetter deadletter.Publisher
+	logger     log.Logger
 }
 
 func (s basicStore) Insert(records []*models.Record) error {
@@ -25,16 +31,22 @@ func (s basicStore) Insert(records []*models.Record) error {
 		return nil
 	}
 
-	elasticRecords, err := s.codec.EncodeElasticRecords(records)
-	if err != nil {
+	elasticRecords, invalid := s.codec.EncodeElasticRecords(records)
+	if err := s.deadLetterRejected(invalid, deadletter.StageEncode); err != nil {
 		return err
 	}
+	if len(elasticRecords) == 0 {
+		return nil
+	}
 
 	for {
 		res, err := s.db.Insert(elasticRecords)
 		if err != nil {
 			return err
 		}
+		if err := s.deadLetterRejected(res.Rejected, deadletter.StageIndex); err != nil {
+			return err
+		}
 		if len(res.Retry) == 0 {
 			break
 		}
@@ -47,15 +59,42 @@ func (s basicStore) Insert(records []*models.Record) error {
 	return nil
 }
 
+func (s basicStore) deadLetterRejected(rejected []*elasticsearch.RejectedRecord, stage deadletter.Stage) error {
+	if s.deadLetter == nil {
+		return nil
+	}
+	for _, r := range rejected {
+		if r.Record == nil || r.Record.Source == nil || r.Record.Source.Message == nil {
+			level.Warn(s.logger).Log("message", "rejected record has no source message, dropping it", "reason", r.Reason)
+			continue
+		}
+		if err := s.deadLetter.Publish(r.Record.Source.Message, stage, errors.New(r.Reason)); err != nil {
+			level.Error(s.logger).Log("err", err, "message", "failed to publish rejected record to dead letter topic")
+			if !deadletter.Retriable(err) {
+				// retrying the batch would fail the same way forever
+				panic(err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 func (s basicStore) ReadinessCheck() bool {
 	return s.db.ReadinessCheck()
 }
 
-func NewStore(logger log.Logger, metricsPublisher metrics.MetricsPublisher) Store {
+func (s basicStore) Close() {
+	s.db.CloseClient()
+}
+
+func NewStore(logger log.Logger, metricsPublisher metrics.MetricsPublisher, deadLetter deadletter.Publisher) Store {
 	config := elasticsearch.NewConfig()
 	return basicStore{
-		db:      elasticsearch.NewDatabase(logger, config, metricsPublisher),
-		codec:   elasticsearch.NewCodec(logger, config),
-		backoff: config.Backoff,
+		db:         elasticsearch.NewDatabase(logger, config, metricsPublisher),
+		codec:      elasticsearch.NewCodec(logger, config),
+		backoff:    config.Backoff,
+		deadLetter: deadLetter,
+		logger:     logger,
 	}
 }
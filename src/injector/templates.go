@@ -0,0 +1,84 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/elasticsearch"
+	"github.com/inloco/kafka-elasticsearch-injector/src/kafka"
+	"github.com/inloco/kafka-elasticsearch-injector/src/schema_registry"
+)
+
+// IndexTemplates generates the index templates of the consumed topics from the
+// latest version of their Avro value schema, registered under the
+// "<topic>-value" subject, keyed by template name. Topics written to the same
+// indexes share the template of the first one. Topics matching a pattern can't
+// be known in advance, so with a pattern the listed topics are used and at
+// least one must be listed.
+func IndexTemplates(schemaRegistry *schema_registry.SchemaRegistry, kafkaConfig *kafka.Config, esConfig elasticsearch.Config) (map[string]map[string]interface{}, error) {
+	switch kafkaConfig.RecordType {
+	case "", "avro":
+	default:
+		return nil, fmt.Errorf("index templates can't be generated for %s records", kafkaConfig.RecordType)
+	}
+	if schemaRegistry == nil {
+		return nil, errors.New("index templates can't be generated without a schema registry")
+	}
+	decoder := &kafka.Decoder{SchemaRegistry: schemaRegistry, TimestampFormat: kafkaConfig.TimestampFormat}
+	mappingsFor := decoder.AvroMappings
+	if kafkaConfig.CDCMode == kafka.CDCModeDebezium {
+		mappingsFor = decoder.DebeziumAvroMappings
+	}
+
+	var topics []string
+	for _, topic := range kafkaConfig.Topics {
+		if topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		if kafkaConfig.TopicsPattern != "" {
+			return nil, errors.New("index templates can't be generated for a topics pattern, list the topics to generate them for")
+		}
+		return nil, errors.New("no topics to generate index templates for")
+	}
+
+	templates := make(map[string]map[string]interface{})
+	for _, topic := range topics {
+		name := elasticsearch.IndexTemplateName(esConfig, topic)
+		if _, exists := templates[name]; exists {
+			continue
+		}
+		schemaId, schema, err := schemaRegistry.GetLatestSchema(topic + "-value")
+		if err != nil {
+			return nil, fmt.Errorf("could not get the value schema of topic %s: %w", topic, err)
+		}
+		mappings, err := mappingsFor(schema, esConfig.TemplateStringType)
+		if err != nil {
+			return nil, fmt.Errorf("could not map the value schema of topic %s: %w", topic, err)
+		}
+		template, err := elasticsearch.IndexTemplate(esConfig, topic, mappings)
+		if err != nil {
+			return nil, err
+		}
+		template["_meta"] = map[string]interface{}{"topic": topic, "schema_id": schemaId}
+		templates[name] = template
+	}
+	return templates, nil
+}
+
+// BootstrapIndexTemplates installs the index templates that don't exist yet.
+func BootstrapIndexTemplates(logger log.Logger, db elasticsearch.RecordDatabase, templates map[string]map[string]interface{}) error {
+	for name, template := range templates {
+		created, err := db.PutIndexTemplate(name, template)
+		if err != nil {
+			return fmt.Errorf("could not create index template %s: %w", name, err)
+		}
+		if !created {
+			level.Info(logger).Log("message", "index template already exists", "template", name)
+		}
+	}
+	return nil
+}
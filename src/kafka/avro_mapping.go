@@ -0,0 +1,186 @@
+package kafka
+
+import "errors"
+
+// String field mappings of generated Elasticsearch mappings.
+const (
+	StringMappingKeyword = "keyword"
+	StringMappingText    = "text"
+)
+
+// AvroMappings returns the Elasticsearch mappings of the records decoded from
+// an Avro value schema, matching how the decoder renders logical types.
+// Fields whose type can't be mapped, such as unions of several types, are
+// left to dynamic mapping.
+func (d *Decoder) AvroMappings(schema string, stringMapping string) (map[string]interface{}, error) {
+	parsed, err := parseAvroSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	return d.avroMappings(parsed, parsed.root, "", stringMapping), nil
+}
+
+// DebeziumAvroMappings returns the Elasticsearch mappings of the rows in the
+// change events of a Debezium Avro envelope schema.
+func (d *Decoder) DebeziumAvroMappings(schema string, stringMapping string) (map[string]interface{}, error) {
+	parsed, err := parseAvroSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	envelope, ok := parsed.root.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("debezium envelope schema is not a record")
+	}
+	_, namespace := avroFullName(envelope, "")
+	fields, _ := envelope["fields"].([]interface{})
+	for _, f := range fields {
+		if field, ok := f.(map[string]interface{}); ok && field["name"] == "after" {
+			return d.avroMappings(parsed, field["type"], namespace, stringMapping), nil
+		}
+	}
+	return nil, errors.New("debezium envelope schema has no after field")
+}
+
+func (d *Decoder) avroMappings(schema *avroSchema, root interface{}, namespace string, stringMapping string) map[string]interface{} {
+	m := avroMapper{
+		schema:          schema,
+		timestampFormat: d.TimestampFormat,
+		stringMapping:   stringMapping,
+		visiting:        make(map[string]bool),
+	}
+	mappings, ok := m.mapping(root, namespace).(map[string]interface{})
+	if !ok || mappings["properties"] == nil {
+		mappings = map[string]interface{}{"properties": map[string]interface{}{}}
+	}
+	properties := mappings["properties"].(map[string]interface{})
+	properties[kafkaTimestampKey] = map[string]interface{}{"type": "date"}
+	return mappings
+}
+
+type avroMapper struct {
+	schema          *avroSchema
+	timestampFormat string
+	stringMapping   string
+	// visiting has the records being mapped, so recursive types are mapped
+	// as plain objects instead of endlessly.
+	visiting map[string]bool
+}
+
+// mapping returns the field mapping of an Avro type, or nil when it's left to
+// dynamic mapping.
+func (m avroMapper) mapping(schema interface{}, namespace string) interface{} {
+	switch t := schema.(type) {
+	case string:
+		if avroPrimitives[t] {
+			return m.primitiveMapping(t)
+		}
+		if named, ok := m.schema.lookup(t, namespace); ok {
+			return m.mapping(named.definition, named.namespace)
+		}
+	case []interface{}:
+		var nonNull []interface{}
+		for _, branch := range t {
+			if branch != "null" {
+				nonNull = append(nonNull, branch)
+			}
+		}
+		if len(nonNull) == 1 {
+			return m.mapping(nonNull[0], namespace)
+		}
+	case map[string]interface{}:
+		typ, ok := t["type"].(string)
+		if !ok {
+			return m.mapping(t["type"], namespace)
+		}
+		switch typ {
+		case "record", "error":
+			fullName, ns := avroFullName(t, namespace)
+			if m.visiting[fullName] {
+				return map[string]interface{}{"type": "object"}
+			}
+			m.visiting[fullName] = true
+			defer delete(m.visiting, fullName)
+			properties := make(map[string]interface{})
+			fields, _ := t["fields"].([]interface{})
+			for _, f := range fields {
+				field, ok := f.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := field["name"].(string)
+				if mapping := m.mapping(field["type"], ns); mapping != nil {
+					properties[name] = mapping
+				}
+			}
+			return map[string]interface{}{"properties": properties}
+		case "array":
+			// Elasticsearch has no array type, any field can hold several values
+			return m.mapping(t["items"], namespace)
+		case "map":
+			return map[string]interface{}{"type": "object"}
+		case "enum":
+			return map[string]interface{}{"type": "keyword"}
+		}
+		if !avroPrimitives[typ] && typ != "fixed" {
+			return m.mapping(typ, namespace)
+		}
+		if logicalType, ok := t["logicalType"].(string); ok {
+			if mapping := m.logicalMapping(logicalType); mapping != nil {
+				return mapping
+			}
+		}
+		if typ == "fixed" {
+			return map[string]interface{}{"type": "binary"}
+		}
+		return m.primitiveMapping(typ)
+	}
+	return nil
+}
+
+func (m avroMapper) primitiveMapping(typ string) interface{} {
+	switch typ {
+	case "boolean":
+		return map[string]interface{}{"type": "boolean"}
+	case "int":
+		return map[string]interface{}{"type": "integer"}
+	case "long":
+		return map[string]interface{}{"type": "long"}
+	case "float":
+		return map[string]interface{}{"type": "float"}
+	case "double":
+		return map[string]interface{}{"type": "double"}
+	case "bytes":
+		return map[string]interface{}{"type": "binary"}
+	case "string":
+		if m.stringMapping == StringMappingText {
+			return map[string]interface{}{
+				"type": "text",
+				"fields": map[string]interface{}{
+					"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256},
+				},
+			}
+		}
+		return map[string]interface{}{"type": "keyword"}
+	}
+	return nil
+}
+
+// logicalMapping maps logical types as normalizeScalar renders them.
+func (m avroMapper) logicalMapping(logicalType string) interface{} {
+	switch logicalType {
+	case "date", "timestamp-millis", "timestamp-micros", "local-timestamp-millis", "local-timestamp-micros":
+		// the default date format parses both ISO-8601 dates and epoch millis
+		return map[string]interface{}{"type": "date"}
+	case "time-millis", "time-micros":
+		if m.timestampFormat == TimestampFormatEpochMillis {
+			return map[string]interface{}{"type": "long"}
+		}
+		return map[string]interface{}{"type": "keyword"}
+	case "decimal":
+		// decimals rendered as strings are coerced to numbers
+		return map[string]interface{}{"type": "double"}
+	case "uuid":
+		return map[string]interface{}{"type": "keyword"}
+	}
+	return nil
+}
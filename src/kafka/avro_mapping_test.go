@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoder_AvroMappings(t *testing.T) {
+	decoder := &Decoder{}
+
+	mappings, err := decoder.AvroMappings(logicalTypesSchema, StringMappingKeyword)
+	if assert.NoError(t, err) {
+		payer := map[string]interface{}{
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{"type": "keyword"},
+			},
+		}
+		assert.Equal(t, map[string]interface{}{
+			"properties": map[string]interface{}{
+				"id":         map[string]interface{}{"type": "keyword"},
+				"created_at": map[string]interface{}{"type": "date"},
+				"day":        map[string]interface{}{"type": "date"},
+				"at":         map[string]interface{}{"type": "keyword"},
+				"amount":     map[string]interface{}{"type": "double"},
+				"note":       map[string]interface{}{"type": "keyword"},
+				"paid_at":    map[string]interface{}{"type": "date"},
+				"payer":      payer,
+				"previous":   payer,
+				"@timestamp": map[string]interface{}{"type": "date"},
+			},
+		}, mappings)
+	}
+}
+
+func TestDecoder_AvroMappings_Types(t *testing.T) {
+	schema := `{
+	  "type": "record",
+	  "name": "Node",
+	  "fields": [
+	    {"name": "count", "type": "int"},
+	    {"name": "total", "type": "long"},
+	    {"name": "enabled", "type": "boolean"},
+	    {"name": "ratio", "type": "float"},
+	    {"name": "score", "type": "double"},
+	    {"name": "raw", "type": "bytes"},
+	    {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["ON", "OFF"]}},
+	    {"name": "labels", "type": {"type": "map", "values": "string"}},
+	    {"name": "title", "type": "string"},
+	    {"name": "either", "type": ["null", "string", "long"]},
+	    {"name": "next", "type": ["null", "Node"]}
+	  ]
+	}`
+	decoder := &Decoder{TimestampFormat: TimestampFormatEpochMillis}
+
+	mappings, err := decoder.AvroMappings(schema, StringMappingText)
+	if assert.NoError(t, err) {
+		properties := mappings["properties"].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"type": "integer"}, properties["count"])
+		assert.Equal(t, map[string]interface{}{"type": "long"}, properties["total"])
+		assert.Equal(t, map[string]interface{}{"type": "boolean"}, properties["enabled"])
+		assert.Equal(t, map[string]interface{}{"type": "float"}, properties["ratio"])
+		assert.Equal(t, map[string]interface{}{"type": "double"}, properties["score"])
+		assert.Equal(t, map[string]interface{}{"type": "binary"}, properties["raw"])
+		assert.Equal(t, map[string]interface{}{"type": "keyword"}, properties["status"])
+		assert.Equal(t, map[string]interface{}{"type": "object"}, properties["labels"])
+		assert.Equal(t, "text", properties["title"].(map[string]interface{})["type"])
+		assert.NotContains(t, properties, "either")
+		assert.Equal(t, map[string]interface{}{"type": "object"}, properties["next"])
+	}
+}
+
+func TestDecoder_DebeziumAvroMappings(t *testing.T) {
+	schema := `{
+	  "type": "record",
+	  "name": "Envelope",
+	  "namespace": "dbserver.inventory.customers",
+	  "fields": [
+	    {"name": "before", "type": ["null", {"type": "record", "name": "Value", "fields": [
+	      {"name": "id", "type": "int"},
+	      {"name": "email", "type": "string"}
+	    ]}]},
+	    {"name": "after", "type": ["null", "Value"]},
+	    {"name": "op", "type": "string"}
+	  ]
+	}`
+	decoder := &Decoder{}
+
+	mappings, err := decoder.DebeziumAvroMappings(schema, StringMappingKeyword)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"properties": map[string]interface{}{
+				"id":         map[string]interface{}{"type": "integer"},
+				"email":      map[string]interface{}{"type": "keyword"},
+				"@timestamp": map[string]interface{}{"type": "date"},
+			},
+		}, mappings)
+	}
+}
@@ -0,0 +1,259 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Formats of Avro logical types in decoded records.
+const (
+	DecimalFormatString = "string"
+	DecimalFormatDouble = "double"
+
+	TimestampFormatISO8601     = "iso8601"
+	TimestampFormatEpochMillis = "epoch_millis"
+)
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true, "float": true,
+	"double": true, "bytes": true, "string": true,
+}
+
+// avroSchema is a parsed Avro schema along with every named type it defines,
+// keyed by full name, so types referenced by name can be resolved.
+type avroSchema struct {
+	root  interface{}
+	named map[string]avroNamedType
+}
+
+type avroNamedType struct {
+	definition map[string]interface{}
+	namespace  string
+}
+
+func parseAvroSchema(schema string) (*avroSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		return nil, err
+	}
+	s := &avroSchema{root: root, named: make(map[string]avroNamedType)}
+	s.collect(root, "")
+	return s, nil
+}
+
+func (s *avroSchema) collect(schema interface{}, namespace string) {
+	switch t := schema.(type) {
+	case []interface{}:
+		for _, branch := range t {
+			s.collect(branch, namespace)
+		}
+	case map[string]interface{}:
+		switch typ := t["type"]; typ {
+		case "record", "error", "enum", "fixed":
+			fullName, ns := avroFullName(t, namespace)
+			s.named[fullName] = avroNamedType{t, ns}
+			fields, _ := t["fields"].([]interface{})
+			for _, f := range fields {
+				if field, ok := f.(map[string]interface{}); ok {
+					s.collect(field["type"], ns)
+				}
+			}
+		case "array":
+			s.collect(t["items"], namespace)
+		case "map":
+			s.collect(t["values"], namespace)
+		default:
+			s.collect(typ, namespace)
+		}
+	}
+}
+
+func (s *avroSchema) lookup(name, namespace string) (avroNamedType, bool) {
+	if named, ok := s.named[name]; ok {
+		return named, true
+	}
+	named, ok := s.named[namespace+"."+name]
+	return named, ok
+}
+
+// branchName is the name goavro uses as key when decoding a union value of
+// the given branch.
+func (s *avroSchema) branchName(branch interface{}, namespace string) string {
+	switch t := branch.(type) {
+	case string:
+		if avroPrimitives[t] {
+			return t
+		}
+		if named, ok := s.lookup(t, namespace); ok {
+			fullName, _ := avroFullName(named.definition, named.namespace)
+			return fullName
+		}
+		return t
+	case map[string]interface{}:
+		typ, _ := t["type"].(string)
+		switch typ {
+		case "record", "error", "enum", "fixed":
+			fullName, _ := avroFullName(t, namespace)
+			if logicalType, ok := t["logicalType"].(string); ok && typ == "fixed" {
+				return "fixed." + logicalType
+			}
+			return fullName
+		}
+		if logicalType, ok := t["logicalType"].(string); ok {
+			return typ + "." + logicalType
+		}
+		return typ
+	}
+	return ""
+}
+
+func avroFullName(definition map[string]interface{}, namespace string) (string, string) {
+	name, _ := definition["name"].(string)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name, name[:i]
+	}
+	if ns, ok := definition["namespace"].(string); ok {
+		namespace = ns
+	}
+	if namespace == "" {
+		return name, ""
+	}
+	return namespace + "." + name, namespace
+}
+
+// avroNormalizer renders goavro native values as values Elasticsearch maps
+// sensibly: logical types become dates, times and decimals in the configured
+// formats and unions are unwrapped from their {"type": value} maps.
+type avroNormalizer struct {
+	decimalFormat   string
+	timestampFormat string
+}
+
+func (n avroNormalizer) normalize(s *avroSchema, schema interface{}, namespace string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch t := schema.(type) {
+	case string:
+		if avroPrimitives[t] {
+			return value
+		}
+		if named, ok := s.lookup(t, namespace); ok {
+			return n.normalize(s, named.definition, named.namespace, value)
+		}
+	case []interface{}:
+		return n.normalizeUnion(s, t, namespace, value)
+	case map[string]interface{}:
+		typ, ok := t["type"].(string)
+		if !ok {
+			return n.normalize(s, t["type"], namespace, value)
+		}
+		switch typ {
+		case "record", "error":
+			record, ok := value.(map[string]interface{})
+			if !ok {
+				return value
+			}
+			_, ns := avroFullName(t, namespace)
+			fields, _ := t["fields"].([]interface{})
+			for _, f := range fields {
+				field, ok := f.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := field["name"].(string)
+				if v, exists := record[name]; exists {
+					record[name] = n.normalize(s, field["type"], ns, v)
+				}
+			}
+			return record
+		case "array":
+			if items, ok := value.([]interface{}); ok {
+				for i, item := range items {
+					items[i] = n.normalize(s, t["items"], namespace, item)
+				}
+			}
+			return value
+		case "map":
+			if values, ok := value.(map[string]interface{}); ok {
+				for k, v := range values {
+					values[k] = n.normalize(s, t["values"], namespace, v)
+				}
+			}
+			return value
+		case "enum":
+			return value
+		}
+		if !avroPrimitives[typ] && typ != "fixed" {
+			return n.normalize(s, typ, namespace, value)
+		}
+		logicalType, _ := t["logicalType"].(string)
+		scale, _ := t["scale"].(float64)
+		return n.normalizeScalar(logicalType, int(scale), value)
+	}
+	return value
+}
+
+// normalizeUnion unwraps a union value. When the branch can't be matched by
+// name, the only non-null branch of the union is assumed.
+func (n avroNormalizer) normalizeUnion(s *avroSchema, branches []interface{}, namespace string, value interface{}) interface{} {
+	wrapped, ok := value.(map[string]interface{})
+	if !ok || len(wrapped) != 1 {
+		return value
+	}
+	for name, v := range wrapped {
+		var nonNull []interface{}
+		for _, branch := range branches {
+			if s.branchName(branch, namespace) == name {
+				return n.normalize(s, branch, namespace, v)
+			}
+			if branch != "null" {
+				nonNull = append(nonNull, branch)
+			}
+		}
+		if len(nonNull) == 1 {
+			return n.normalize(s, nonNull[0], namespace, v)
+		}
+		return v
+	}
+	return value
+}
+
+func (n avroNormalizer) normalizeScalar(logicalType string, scale int, value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		if n.timestampFormat == TimestampFormatEpochMillis {
+			return makeTimestamp(v)
+		}
+		if logicalType == "date" {
+			return v.UTC().Format("2006-01-02")
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case time.Duration:
+		if n.timestampFormat == TimestampFormatEpochMillis {
+			return int64(v / time.Millisecond)
+		}
+		return formatTimeOfDay(v)
+	case *big.Rat:
+		if n.decimalFormat == DecimalFormatDouble {
+			f, _ := v.Float64()
+			return f
+		}
+		return v.FloatString(scale)
+	}
+	return value
+}
+
+// formatTimeOfDay formats Avro time-millis and time-micros values, which are
+// durations since midnight, as ISO-8601 times.
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d:%02d.%06d",
+		int(d/time.Hour),
+		int(d%time.Hour/time.Minute),
+		int(d%time.Minute/time.Second),
+		int(d%time.Second/time.Microsecond),
+	)
+}
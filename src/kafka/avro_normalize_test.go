@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/inloco/kafka-elasticsearch-injector/src/schema_registry"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const logicalTypesSchema = `{
+  "type": "record",
+  "name": "Payment",
+  "namespace": "com.example",
+  "fields": [
+    {"name": "id", "type": {"type": "string", "logicalType": "uuid"}},
+    {"name": "created_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "day", "type": {"type": "int", "logicalType": "date"}},
+    {"name": "at", "type": {"type": "int", "logicalType": "time-millis"}},
+    {"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+    {"name": "note", "type": ["null", "string"]},
+    {"name": "paid_at", "type": ["null", {"type": "long", "logicalType": "timestamp-millis"}]},
+    {"name": "payer", "type": ["null", {"type": "record", "name": "Payer", "fields": [{"name": "name", "type": "string"}]}]},
+    {"name": "previous", "type": {"type": "array", "items": ["null", "Payer"]}}
+  ]
+}`
+
+func encodePayment(t *testing.T) []byte {
+	codec, err := goavro.NewCodec(logicalTypesSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := codec.BinaryFromNative(nil, map[string]interface{}{
+		"id":         "5bd9a8d6-5b0c-4f1e-9a55-9e4b1f6a3f10",
+		"created_at": time.Date(2020, 3, 4, 5, 6, 7, 8000000, time.UTC),
+		"day":        time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC),
+		"at":         5*time.Hour + 6*time.Minute + 7*time.Second,
+		"amount":     big.NewRat(12345, 100),
+		"note":       goavro.Union("string", "first"),
+		"paid_at":    goavro.Union("long.timestamp-millis", time.Date(2020, 3, 5, 0, 0, 0, 0, time.UTC)),
+		"payer":      goavro.Union("com.example.Payer", map[string]interface{}{"name": "acme"}),
+		"previous":   []interface{}{nil, goavro.Union("com.example.Payer", map[string]interface{}{"name": "old"})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte{0, 0, 0, 0, 9}, payment...) // magic byte and schema id
+}
+
+func newAvroRegistry(t *testing.T) (*schema_registry.SchemaRegistry, func()) {
+	return newTestRegistry(t, map[string]interface{}{
+		"/schemas/ids/9": map[string]interface{}{"schema": logicalTypesSchema},
+	})
+}
+
+func TestDecoder_AvroMessageToRecord_LogicalTypes(t *testing.T) {
+	registry, closeRegistry := newAvroRegistry(t)
+	defer closeRegistry()
+	d := &Decoder{SchemaRegistry: registry}
+
+	record, err := d.AvroMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     encodePayment(t),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "5bd9a8d6-5b0c-4f1e-9a55-9e4b1f6a3f10", record.Json["id"])
+		assert.Equal(t, "2020-03-04T05:06:07.008Z", record.Json["created_at"])
+		assert.Equal(t, "2020-03-04", record.Json["day"])
+		assert.Equal(t, "05:06:07.000000", record.Json["at"])
+		assert.Equal(t, "123.45", record.Json["amount"])
+		assert.Equal(t, "first", record.Json["note"])
+		assert.Equal(t, "2020-03-05T00:00:00Z", record.Json["paid_at"])
+		assert.Equal(t, map[string]interface{}{"name": "acme"}, record.Json["payer"])
+		assert.Equal(t, []interface{}{nil, map[string]interface{}{"name": "old"}}, record.Json["previous"])
+	}
+}
+
+func TestDecoder_AvroMessageToRecord_EpochMillisAndDoubles(t *testing.T) {
+	registry, closeRegistry := newAvroRegistry(t)
+	defer closeRegistry()
+	d := &Decoder{
+		SchemaRegistry:  registry,
+		DecimalFormat:   DecimalFormatDouble,
+		TimestampFormat: TimestampFormatEpochMillis,
+	}
+
+	record, err := d.AvroMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     encodePayment(t),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1583298367008), record.Json["created_at"])
+		assert.Equal(t, int64(1583280000000), record.Json["day"])
+		assert.Equal(t, int64(18367000), record.Json["at"])
+		assert.Equal(t, 123.45, record.Json["amount"])
+	}
+}
@@ -1,17 +1,68 @@
 package kafka
 
+import (
+	"errors"
+	"strings"
+)
+
 const (
 	ConsumerType = "consumer"
 )
 
 type Config struct {
 	Type                  string
+	Brokers               []string
+	Version               string
+	ClientID              string
+	InitialOffset         string
+	FetchMinBytes         string
+	FetchMaxBytes         string
+	SessionTimeout        string
+	HeartbeatInterval     string
+	IsolationLevel        string
 	Topics                []string
+	TopicsPattern         string
+	TopicsRefreshInterval string
 	ConsumerGroup         string
 	Concurrency           string
 	BatchSize             string
 	MetricsUpdateInterval string
 	BufferSize            string
 	RecordType            string
+	CDCMode               string
+	DeleteOnTombstone     string
+	ValidateSchema        string
+	DecimalFormat         string
+	TimestampFormat       string
 	IncludeKey            string
+	KeyFormat             string
+	IncludeMetadata       string
+	MetadataField         string
+	MetadataHeaders       []string
+	Filter                string
+	FlushInterval         string
+	ShutdownTimeout       string
+	SASLMechanism         string
+	SASLUsername          string
+	SASLPassword          string
+	TLSEnabled            string
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify string
+}
+
+// ParseBrokers parses a comma separated list of broker addresses, ignoring
+// spaces and empty entries. At least one broker is required.
+func ParseBrokers(addresses string) ([]string, error) {
+	var brokers []string
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			brokers = append(brokers, address)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, errors.New("no kafka brokers configured")
+	}
+	return brokers, nil
 }
@@ -0,0 +1,19 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBrokers(t *testing.T) {
+	brokers, err := ParseBrokers(" kafka-1:9092, ,kafka-2:9092 ,")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"kafka-1:9092", "kafka-2:9092"}, brokers)
+	}
+
+	for _, addresses := range []string{"", " ", ",, "} {
+		_, err := ParseBrokers(addresses)
+		assert.Error(t, err, addresses)
+	}
+}
@@ -4,17 +4,16 @@ import (
 	"context"
 	"errors"
 	"os"
+	"regexp"
 
 	"time"
 
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	e "github.com/inloco/kafka-elasticsearch-injector/src/errors"
+	"github.com/inloco/kafka-elasticsearch-injector/src/deadletter"
 	"github.com/inloco/kafka-elasticsearch-injector/src/metrics"
-	"github.com/inloco/kafka-elasticsearch-injector/src/models"
 )
 
 type Notification int32
@@ -24,17 +23,24 @@ const (
 	Inserted
 )
 
+// ErrShutdownTimeout is returned when the consumer stops before its workers
+// flush their buffered messages. The workers may still be calling the
+// endpoint, so whatever it uses must be left open.
+var ErrShutdownTimeout = errors.New("shutdown timeout reached before flushing all buffered messages")
+
 type kafka struct {
 	consumer         Consumer
-	consumerCh       chan *sarama.ConsumerMessage
 	offsetCh         chan *topicPartitionOffset
-	config           *cluster.Config
+	config           *sarama.Config
 	brokers          []string
 	metricsPublisher metrics.MetricsPublisher
+	timestampTypes   *timestampTypes
 }
 
 type Consumer struct {
 	Topics                []string
+	TopicsPattern         *regexp.Regexp
+	TopicsRefreshInterval time.Duration
 	Group                 string
 	Endpoint              endpoint.Endpoint
 	Decoder               DecodeMessageFunc
@@ -44,6 +50,15 @@ type Consumer struct {
 	MetricsUpdateInterval time.Duration
 	BufferSize            int
 	IncludeKey            bool
+	FlushInterval         time.Duration
+	DeadLetter            deadletter.Publisher
+	Filter                *Filter // records not matching the filter are skipped, nil to send every record
+	ShutdownTimeout       time.Duration
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
 }
 
 type topicPartitionOffset struct {
@@ -52,127 +67,154 @@ type topicPartitionOffset struct {
 	offset    int64
 }
 
-func NewKafka(address string, consumer Consumer, metrics metrics.MetricsPublisher) kafka {
-	brokers := []string{address}
-	config := cluster.NewConfig()
+// NewKafka creates the consumer on top of config, usually built by
+// NewSaramaConfig.
+func NewKafka(brokers []string, config *sarama.Config, consumer Consumer, metrics metrics.MetricsPublisher) kafka {
 	config.Consumer.Return.Errors = true
-	config.Group.Return.Notifications = true
-
-	config.Version = sarama.V2_3_0_0
 
 	return kafka{
 		brokers:          brokers,
 		config:           config,
 		consumer:         consumer,
 		metricsPublisher: metrics,
-		consumerCh:       make(chan *sarama.ConsumerMessage, consumer.BufferSize),
 		offsetCh:         make(chan *topicPartitionOffset),
 	}
 }
 
-func (k *kafka) Start(signals chan os.Signal, notifications chan<- Notification) {
-	topics := k.consumer.Topics
-	concurrency := k.consumer.Concurrency
-	consumer, err := cluster.NewConsumer(k.brokers, k.consumer.Group, topics, k.config)
+// Start consumes the subscribed topics until a signal is received. It returns
+// ErrShutdownTimeout if the buffered messages weren't flushed in time.
+func (k *kafka) Start(signals chan os.Signal, notifications chan<- Notification) error {
+	client, err := sarama.NewClient(k.brokers, k.config)
 	if err != nil {
 		panic(err)
 	}
-	defer consumer.Close()
+	group, err := sarama.NewConsumerGroupFromClient(k.consumer.Group, client)
+	if err != nil {
+		panic(err)
+	}
+	k.timestampTypes = newTimestampTypes(client, k.consumer.Logger)
 
-	buffSize := k.consumer.BatchSize
-	for i := 0; i < concurrency; i++ {
-		go k.worker(consumer, buffSize, notifications)
+	subscription := newTopicSubscription(k.consumer.Topics, k.consumer.TopicsPattern)
+	if err := subscription.resolve(client); err != nil {
+		panic(err)
 	}
+	go subscription.watch(client, k.consumer.TopicsRefreshInterval, k.consumer.Logger)
+
+	handler := &consumerGroupHandler{
+		kafka:         k,
+		notifications: notifications,
+	}
+	go k.updateOffsets()
+
 	go func() {
-		for {
-			offset := <-k.offsetCh
-			k.metricsPublisher.UpdateOffset(offset.topic, offset.partition, offset.offset)
+		for range time.Tick(k.consumer.MetricsUpdateInterval) {
+			k.metricsPublisher.PublishOffsetMetrics(handler.highWaterMarks())
 		}
 	}()
 
 	go func() {
-		for range time.Tick(k.consumer.MetricsUpdateInterval) {
-			k.metricsPublisher.PublishOffsetMetrics(consumer.HighWaterMarks())
+		for err := range group.Errors() {
+			level.Error(k.consumer.Logger).Log(
+				"message", "Failed to consume message",
+				"err", err.Error(),
+			)
 		}
 	}()
 
-	// consume messages, watch errors and notifications
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		k.consume(ctx, group, subscription, handler)
+	}()
+
+	select {
+	case <-signals:
+	case <-stopped:
+	}
+	return k.shutdown(client, group, cancel, stopped)
+}
+
+func (k *kafka) updateOffsets() {
 	for {
-		select {
-		case msg, more := <-consumer.Messages():
-			if more {
-				if len(k.consumerCh) >= cap(k.consumerCh) {
-					level.Warn(k.consumer.Logger).Log(
-						"message", "Buffer is full ",
-						"channelSize", cap(k.consumerCh),
-					)
-					k.metricsPublisher.BufferFull(true)
-				}
-				k.consumerCh <- msg
-				k.metricsPublisher.BufferFull(false)
-			}
-		case err, more := <-consumer.Errors():
-			if more {
-				level.Error(k.consumer.Logger).Log(
-					"message", "Failed to consume message",
-					"err", err.Error(),
-				)
-			}
-		case ntf, more := <-consumer.Notifications():
-			if more {
-				level.Info(k.consumer.Logger).Log(
-					"message", "Partitions rebalanced",
-					"notification", ntf,
-				)
-				if ntf.Type == cluster.RebalanceOK {
-					notifications <- Ready
-				}
-			}
-		case <-signals:
-			return
-		}
+		offset := <-k.offsetCh
+		k.metricsPublisher.UpdateOffset(offset.topic, offset.partition, offset.offset)
 	}
 }
 
-func (k *kafka) worker(consumer *cluster.Consumer, buffSize int, notifications chan<- Notification) {
-	buf := make([]*sarama.ConsumerMessage, buffSize)
-	var decoded []*models.Record
-	idx := 0
+// consume runs group sessions until ctx is done. Sessions are also ended when
+// the subscribed topics change, so the next one joins the group with them.
+func (k *kafka) consume(ctx context.Context, group sarama.ConsumerGroup, subscription *topicSubscription, handler sarama.ConsumerGroupHandler) {
 	for {
-		kafkaMsg := <-k.consumerCh
-		buf[idx] = kafkaMsg
-		idx++
-		for idx == buffSize {
-			if decoded == nil {
-				for _, msg := range buf {
-					req, err := k.consumer.Decoder(nil, msg, k.consumer.IncludeKey)
-					if err != nil {
-						if errors.Is(err, e.ErrNilMessage) {
-							continue
-						}
-
-						level.Error(k.consumer.Logger).Log(
-							"message", "Error decoding message",
-							"err", err.Error(),
-						)
-						continue
-					}
-					decoded = append(decoded, req)
-				}
-			}
-			if res, err := k.consumer.Endpoint(context.Background(), decoded); err != nil {
-				level.Error(k.consumer.Logger).Log("message", "error on endpoint call", "err", err.Error())
-				var _ = res // ignore res (for now)
+		topics := subscription.current()
+		k.timestampTypes.resolve(topics)
+		if len(topics) == 0 {
+			level.Warn(k.consumer.Logger).Log("message", "No topics to subscribe to, waiting for matching topics")
+			select {
+			case <-subscription.changed:
 				continue
+			case <-ctx.Done():
+				return
 			}
-			notifications <- Inserted
-			k.metricsPublisher.IncrementRecordsConsumed(buffSize)
-			for _, msg := range buf {
-				k.offsetCh <- &topicPartitionOffset{msg.Topic, msg.Partition, msg.Offset}
-				consumer.MarkOffset(msg, "") // mark message as processed
+		}
+
+		sessionCtx, endSession := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-subscription.changed:
+				endSession()
+			case <-sessionCtx.Done():
 			}
-			decoded = nil
-			idx = 0
+		}()
+		// Consume blocks for a whole group session, it has to be called
+		// again after every rebalance to join the group with the new claims.
+		err := group.Consume(sessionCtx, topics, handler)
+		endSession()
+		if err == sarama.ErrClosedConsumerGroup {
+			return
+		}
+		if err != nil {
+			level.Error(k.consumer.Logger).Log(
+				"message", "Consumer group session failed",
+				"err", err.Error(),
+			)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// shutdown ends the current group session and waits, for at most the
+// consumer's ShutdownTimeout, until the workers flush their partial batches
+// and the session commits the offsets of everything flushed.
+func (k *kafka) shutdown(client sarama.Client, group sarama.ConsumerGroup, cancel context.CancelFunc, stopped <-chan struct{}) error {
+	level.Info(k.consumer.Logger).Log("message", "Shutting down, flushing buffered messages")
+	cancel()
+
+	select {
+	case <-stopped:
+		level.Info(k.consumer.Logger).Log("message", "Buffered messages flushed")
+		if err := group.Close(); err != nil {
+			level.Error(k.consumer.Logger).Log(
+				"message", "Failed to close consumer group",
+				"err", err.Error(),
+			)
+		}
+		if err := client.Close(); err != nil {
+			level.Error(k.consumer.Logger).Log(
+				"message", "Failed to close kafka client",
+				"err", err.Error(),
+			)
 		}
+		return nil
+	case <-time.After(k.consumer.ShutdownTimeout):
+		// the group can't be closed while a session is still running, offsets
+		// marked so far are left to the periodic auto commit.
+		level.Warn(k.consumer.Logger).Log(
+			"message", "Shutdown timeout reached before flushing all buffered messages",
+			"timeout", k.consumer.ShutdownTimeout,
+		)
+		return ErrShutdownTimeout
 	}
 }
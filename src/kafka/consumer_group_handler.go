@@ -0,0 +1,244 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/deadletter"
+	e "github.com/inloco/kafka-elasticsearch-injector/src/errors"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// consumerGroupHandler runs a pool of workers for each consumer group session.
+// Messages of every claim are fanned out to the workers, which batch them and
+// mark their offsets in the session once they are sent to the endpoint.
+// Partial batches are flushed in Cleanup, before the session commits its
+// offsets and its partitions are handed to other members.
+type consumerGroupHandler struct {
+	*kafka
+	notifications chan<- Notification
+	consumerCh    chan *sarama.ConsumerMessage
+	workers       sync.WaitGroup
+	claims        sync.Map
+}
+
+// offsetMarker marks messages as processed once they are sent to the endpoint.
+// It is implemented by sarama.ConsumerGroupSession.
+type offsetMarker interface {
+	MarkMessage(msg *sarama.ConsumerMessage, metadata string)
+}
+
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	level.Info(h.consumer.Logger).Log(
+		"message", "Partitions rebalanced",
+		"claims", session.Claims(),
+	)
+	h.startWorkers(session)
+	h.notifications <- Ready
+	return nil
+}
+
+func (h *consumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	level.Info(h.consumer.Logger).Log(
+		"message", "Session ended, flushing buffered messages",
+		"buffered", len(h.consumerCh),
+	)
+	h.stopWorkers()
+	return nil
+}
+
+func (h *consumerGroupHandler) startWorkers(marker offsetMarker) {
+	h.consumerCh = make(chan *sarama.ConsumerMessage, h.consumer.BufferSize)
+	for i := 0; i < h.consumer.Concurrency; i++ {
+		h.workers.Add(1)
+		go func() {
+			defer h.workers.Done()
+			h.worker(marker, h.consumer.BatchSize)
+		}()
+	}
+}
+
+// stopWorkers waits until the workers flush every buffered message.
+func (h *consumerGroupHandler) stopWorkers() {
+	close(h.consumerCh)
+	h.workers.Wait()
+}
+
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tp := topicPartition{claim.Topic(), claim.Partition()}
+	h.claims.Store(tp, claim)
+	defer h.claims.Delete(tp)
+
+	for msg := range claim.Messages() {
+		if len(h.consumerCh) >= cap(h.consumerCh) {
+			level.Warn(h.consumer.Logger).Log(
+				"message", "Buffer is full ",
+				"channelSize", cap(h.consumerCh),
+			)
+			h.metricsPublisher.BufferFull(true)
+		}
+		select {
+		case h.consumerCh <- msg:
+		case <-session.Context().Done():
+			// msg was not handed to any worker, so its offset is not marked
+			// and it will be consumed again by the next owner of the partition.
+			return nil
+		}
+		h.metricsPublisher.BufferFull(false)
+	}
+	return nil
+}
+
+func (h *consumerGroupHandler) highWaterMarks() map[string]map[int32]int64 {
+	highWaterMarks := make(map[string]map[int32]int64)
+	h.claims.Range(func(_, value interface{}) bool {
+		claim := value.(sarama.ConsumerGroupClaim)
+		if _, exists := highWaterMarks[claim.Topic()]; !exists {
+			highWaterMarks[claim.Topic()] = make(map[int32]int64)
+		}
+		highWaterMarks[claim.Topic()][claim.Partition()] = claim.HighWaterMarkOffset()
+		return true
+	})
+	return highWaterMarks
+}
+
+func (h *consumerGroupHandler) worker(marker offsetMarker, buffSize int) {
+	buf := make([]*sarama.ConsumerMessage, buffSize)
+	idx := 0
+	// flushC fires FlushInterval after the first message of a partial batch
+	// arrives, so low volume topics don't keep records (and offsets) waiting
+	// for a full batch. It stays nil when the flush interval is disabled.
+	var flushTimer *time.Timer
+	var flushC <-chan time.Time
+	for {
+		select {
+		case kafkaMsg, more := <-h.consumerCh:
+			if !more {
+				if flushTimer != nil {
+					flushTimer.Stop()
+				}
+				if idx > 0 {
+					h.flush(marker, buf[:idx])
+				}
+				return
+			}
+			buf[idx] = kafkaMsg
+			idx++
+			if idx == 1 && h.consumer.FlushInterval > 0 {
+				flushTimer = time.NewTimer(h.consumer.FlushInterval)
+				flushC = flushTimer.C
+			}
+			if idx < buffSize {
+				continue
+			}
+		case <-flushC:
+		}
+		if flushTimer != nil {
+			flushTimer.Stop()
+			flushTimer, flushC = nil, nil
+		}
+		h.flush(marker, buf[:idx])
+		idx = 0
+	}
+}
+
+func (h *consumerGroupHandler) flush(marker offsetMarker, msgs []*sarama.ConsumerMessage) {
+	var decoded []*models.Record
+	filtered := make(map[string]int)
+	for _, msg := range msgs {
+		req, err := h.consumer.Decoder(nil, msg, h.consumer.IncludeKey)
+		if err != nil {
+			if errors.Is(err, e.ErrNilMessage) || errors.Is(err, e.ErrSkippedMessage) {
+				continue
+			}
+
+			stage := deadletter.StageDecode
+			if errors.Is(err, e.ErrSchemaValidation) {
+				stage = deadletter.StageValidation
+			}
+			level.Error(h.consumer.Logger).Log(
+				"message", "Error decoding message",
+				"stage", stage,
+				"err", err.Error(),
+			)
+			h.deadLetter(msg, stage, err)
+			continue
+		}
+		h.timestampTypes.setTimestampType(req)
+		// deletes have no fields to filter by, and must still reach the documents
+		if h.consumer.Filter != nil && !req.Delete && !h.consumer.Filter.Match(req) {
+			filtered[msg.Topic]++
+			continue
+		}
+		decoded = append(decoded, req)
+	}
+	for topic, count := range filtered {
+		h.metricsPublisher.IncrementRecordsFiltered(topic, count)
+	}
+	for {
+		if res, err := h.consumer.Endpoint(context.Background(), decoded); err != nil {
+			level.Error(h.consumer.Logger).Log("message", "error on endpoint call", "err", err.Error())
+			var _ = res // ignore res (for now)
+			continue
+		}
+		break
+	}
+	h.notifications <- Inserted
+	consumed := make(map[string]int)
+	for _, msg := range msgs {
+		consumed[msg.Topic]++
+		h.offsetCh <- &topicPartitionOffset{msg.Topic, msg.Partition, msg.Offset}
+		marker.MarkMessage(msg, "") // mark message as processed
+	}
+	for topic, count := range consumed {
+		h.metricsPublisher.IncrementRecordsConsumed(topic, count)
+	}
+}
+
+// Bounds of the exponential backoff between attempts to dead-letter a message.
+const (
+	deadLetterMinBackoff = 100 * time.Millisecond
+	deadLetterMaxBackoff = 10 * time.Second
+)
+
+// deadLetter publishes msg to the dead letter topic, if one is configured,
+// retrying until it succeeds so the message offset is never committed before
+// the message is safely stored somewhere. Errors that can't be retried, like
+// messages over the broker size limit or a topic the producer isn't
+// authorized for, are fatal: the offset is left uncommitted and the injector
+// stops instead of stalling the partition forever.
+func (h *consumerGroupHandler) deadLetter(msg *sarama.ConsumerMessage, stage deadletter.Stage, cause error) {
+	if h.consumer.DeadLetter == nil {
+		return
+	}
+	backoff := deadLetterMinBackoff
+	for {
+		err := h.consumer.DeadLetter.Publish(msg, stage, cause)
+		if err == nil {
+			return
+		}
+		if !deadletter.Retriable(err) {
+			level.Error(h.consumer.Logger).Log(
+				"message", "dead letter topic rejected the message, stopping",
+				"topic", msg.Topic,
+				"partition", msg.Partition,
+				"offset", msg.Offset,
+				"err", err.Error(),
+			)
+			panic(err)
+		}
+		level.Error(h.consumer.Logger).Log(
+			"message", "error publishing to dead letter topic",
+			"retryIn", backoff,
+			"err", err.Error(),
+		)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > deadLetterMaxBackoff {
+			backoff = deadLetterMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/deadletter"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// fakeSession records the offsets marked by the handler.
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	lock   sync.Mutex
+	marked []int64
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) markedOffsets() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+func (s *fakeSession) Claims() map[string][]int32 {
+	return map[string][]int32{"my-topic": {0}}
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+// recordingEndpoint sends the offsets of every batch it receives to batches,
+// failing the first failures calls.
+type recordingEndpoint struct {
+	batches  chan []int64
+	failures int
+}
+
+func (e *recordingEndpoint) insert(_ context.Context, request interface{}) (interface{}, error) {
+	if e.failures > 0 {
+		e.failures--
+		return nil, errors.New("elasticsearch is unavailable")
+	}
+	var offsets []int64
+	for _, record := range request.([]*models.Record) {
+		offsets = append(offsets, record.Offset)
+	}
+	e.batches <- offsets
+	return nil, nil
+}
+
+func newTestHandler(endpoint *recordingEndpoint, batchSize int, flushInterval time.Duration) *consumerGroupHandler {
+	return &consumerGroupHandler{
+		kafka: &kafka{
+			consumer: Consumer{
+				Endpoint: endpoint.insert,
+				Decoder: func(_ context.Context, msg *sarama.ConsumerMessage, _ bool) (*models.Record, error) {
+					return &models.Record{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}, nil
+				},
+				Logger:        log.NewNopLogger(),
+				Concurrency:   1,
+				BatchSize:     batchSize,
+				BufferSize:    10,
+				FlushInterval: flushInterval,
+			},
+			offsetCh:         make(chan *topicPartitionOffset, 10),
+			metricsPublisher: metricsPublisher,
+		},
+		notifications: make(chan Notification, 10),
+	}
+}
+
+func receiveBatch(t *testing.T, batches chan []int64) []int64 {
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch was sent to the endpoint")
+		return nil
+	}
+}
+
+func TestConsumerGroupHandler_FlushInterval(t *testing.T) {
+	endpoint := &recordingEndpoint{batches: make(chan []int64, 10)}
+	h := newTestHandler(endpoint, 10, 50*time.Millisecond)
+	session := &fakeSession{ctx: context.Background()}
+	h.startWorkers(session)
+
+	h.consumerCh <- &sarama.ConsumerMessage{Topic: "my-topic", Offset: 0}
+	h.consumerCh <- &sarama.ConsumerMessage{Topic: "my-topic", Offset: 1}
+
+	// the partial batch is flushed once the interval elapses
+	assert.Equal(t, []int64{0, 1}, receiveBatch(t, endpoint.batches))
+	h.stopWorkers()
+	assert.Equal(t, []int64{0, 1}, session.markedOffsets())
+}
+
+func TestConsumerGroupHandler_FlushesFullBatches(t *testing.T) {
+	endpoint := &recordingEndpoint{batches: make(chan []int64, 10)}
+	h := newTestHandler(endpoint, 2, 0)
+	session := &fakeSession{ctx: context.Background()}
+	h.startWorkers(session)
+
+	for offset := int64(0); offset < 3; offset++ {
+		h.consumerCh <- &sarama.ConsumerMessage{Topic: "my-topic", Offset: offset}
+	}
+
+	assert.Equal(t, []int64{0, 1}, receiveBatch(t, endpoint.batches))
+	select {
+	case batch := <-endpoint.batches:
+		t.Fatalf("partial batch %v flushed without a flush interval", batch)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(t, []int64{0, 1}, session.markedOffsets())
+	h.stopWorkers()
+}
+
+func TestConsumerGroupHandler_MarksOffsetsAfterInsert(t *testing.T) {
+	endpoint := &recordingEndpoint{batches: make(chan []int64), failures: 2}
+	h := newTestHandler(endpoint, 2, 0)
+	session := &fakeSession{ctx: context.Background()}
+	h.startWorkers(session)
+
+	h.consumerCh <- &sarama.ConsumerMessage{Topic: "my-topic", Partition: 0, Offset: 7}
+	h.consumerCh <- &sarama.ConsumerMessage{Topic: "my-topic", Partition: 0, Offset: 8}
+
+	// the endpoint blocks on the unbuffered channel after its failures, so
+	// nothing is marked until the batch is stored
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, session.markedOffsets())
+	assert.Equal(t, []int64{7, 8}, receiveBatch(t, endpoint.batches))
+
+	for _, expected := range []int64{7, 8} {
+		offset := <-h.offsetCh
+		assert.Equal(t, topicPartitionOffset{"my-topic", 0, expected}, *offset)
+	}
+	h.stopWorkers()
+	assert.Equal(t, []int64{7, 8}, session.markedOffsets())
+}
+
+// fakeClaim delivers messages of a single partition.
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string {
+	return "my-topic"
+}
+
+func (c *fakeClaim) Partition() int32 {
+	return 0
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func TestConsumerGroupHandler_CleanupFlushesBufferedRecords(t *testing.T) {
+	endpoint := &recordingEndpoint{batches: make(chan []int64, 10)}
+	h := newTestHandler(endpoint, 10, 0)
+	session := &fakeSession{ctx: context.Background()}
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	for offset := int64(0); offset < 3; offset++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "my-topic", Offset: offset}
+	}
+	close(claim.messages)
+
+	if !assert.NoError(t, h.Setup(session)) {
+		return
+	}
+	assert.NoError(t, h.ConsumeClaim(session, claim))
+	assert.Empty(t, session.markedOffsets())
+
+	// the rebalance ends the session, the partial batch is flushed before the
+	// partition is handed to another member
+	assert.NoError(t, h.Cleanup(session))
+	assert.Equal(t, []int64{0, 1, 2}, receiveBatch(t, endpoint.batches))
+	assert.Equal(t, []int64{0, 1, 2}, session.markedOffsets())
+}
+
+func TestConsumerGroupHandler_FiltersByTimestampType(t *testing.T) {
+	endpoint := &recordingEndpoint{batches: make(chan []int64, 10)}
+	h := newTestHandler(endpoint, 10, 0)
+	h.consumer.Decoder = func(_ context.Context, msg *sarama.ConsumerMessage, _ bool) (*models.Record, error) {
+		return &models.Record{Topic: msg.Topic, Offset: msg.Offset, Metadata: map[string]interface{}{}}, nil
+	}
+	filter, err := ParseFilter(`@metadata.timestamp_type == "LogAppendTime"`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h.consumer.Filter = filter
+	h.timestampTypes = &timestampTypes{logger: log.NewNopLogger()}
+	h.timestampTypes.types.Store("appended", "LogAppendTime")
+	h.timestampTypes.types.Store("created", "CreateTime")
+
+	session := &fakeSession{ctx: context.Background()}
+	h.flush(session, []*sarama.ConsumerMessage{
+		{Topic: "appended", Offset: 0},
+		{Topic: "created", Offset: 1},
+	})
+	assert.Equal(t, []int64{0}, receiveBatch(t, endpoint.batches))
+	assert.Equal(t, []int64{0, 1}, session.markedOffsets())
+}
+
+// failingPublisher fails with the given errors before publishing.
+type failingPublisher struct {
+	errs      []error
+	attempts  int
+	published []int64
+}
+
+func (p *failingPublisher) Publish(msg *sarama.ConsumerMessage, _ deadletter.Stage, _ error) error {
+	p.attempts++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return err
+	}
+	p.published = append(p.published, msg.Offset)
+	return nil
+}
+
+func (p *failingPublisher) Close() error {
+	return nil
+}
+
+func TestConsumerGroupHandler_DeadLetterRetriesWithBackoff(t *testing.T) {
+	publisher := &failingPublisher{errs: []error{sarama.ErrNotLeaderForPartition, sarama.ErrNotLeaderForPartition}}
+	h := newTestHandler(&recordingEndpoint{}, 10, 0)
+	h.consumer.DeadLetter = publisher
+
+	start := time.Now()
+	h.deadLetter(&sarama.ConsumerMessage{Topic: "my-topic", Offset: 3}, deadletter.StageDecode, errors.New("bad magic byte"))
+	assert.True(t, time.Since(start) >= deadLetterMinBackoff*3)
+	assert.Equal(t, 3, publisher.attempts)
+	assert.Equal(t, []int64{3}, publisher.published)
+}
+
+func TestConsumerGroupHandler_DeadLetterDoesNotRetryFatalErrors(t *testing.T) {
+	publisher := &failingPublisher{errs: []error{sarama.ErrMessageSizeTooLarge}}
+	h := newTestHandler(&recordingEndpoint{}, 10, 0)
+	h.consumer.DeadLetter = publisher
+
+	assert.Panics(t, func() {
+		h.deadLetter(&sarama.ConsumerMessage{Topic: "my-topic", Offset: 3}, deadletter.StageDecode, errors.New("bad magic byte"))
+	})
+	assert.Equal(t, 1, publisher.attempts)
+	assert.Empty(t, publisher.published)
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 
 	"encoding/json"
+	"errors"
 
 	"github.com/Shopify/sarama"
 	"github.com/go-kit/kit/endpoint"
@@ -34,11 +35,11 @@ func (s fixtureService) Insert(records []*models.Record) error {
 		return nil
 	}
 
-	elasticRecords, err := s.codec.EncodeElasticRecords(records)
-	if err != nil {
-		return err
+	elasticRecords, rejected := s.codec.EncodeElasticRecords(records)
+	if len(rejected) > 0 {
+		return errors.New(rejected[0].Reason)
 	}
-	_, err = s.db.Insert(elasticRecords)
+	_, err := s.db.Insert(elasticRecords)
 	return err
 }
 
@@ -98,7 +99,11 @@ func TestMain(m *testing.M) {
 		BatchSize:             1,
 		MetricsUpdateInterval: 30 * time.Second,
 	}
-	k = NewKafka("localhost:9092", consumer, metricsPublisher)
+	saramaConfig, err := NewSaramaConfig(&Config{})
+	if err != nil {
+		panic(err)
+	}
+	k = NewKafka([]string{"localhost:9092"}, saramaConfig, consumer, metricsPublisher)
 	retCode := m.Run()
 	os.Exit(retCode)
 }
@@ -113,7 +118,7 @@ func TestKafka_Start(t *testing.T) {
 	config.Producer.Flush.Frequency = 1 * time.Millisecond
 	config.Version = sarama.V2_3_0_0
 	<-notifications
-	producer, err := fixtures.NewProducer("localhost:9092", config, schemaRegistry)
+	producer, err := fixtures.NewProducer([]string{"localhost:9092"}, config, schemaRegistry)
 	expectedTimestamp := time.Now().UnixNano() / int64(time.Millisecond)
 	rec := fixtures.NewFixtureRecord()
 	var msg *sarama.ProducerMessage
@@ -145,3 +150,41 @@ func TestKafka_Start(t *testing.T) {
 	db.GetClient().DeleteByQuery(esIndex).Query(elastic.MatchAllQuery{}).Do(context.Background())
 	db.CloseClient()
 }
+
+// closeRecordingClient records whether it was closed.
+type closeRecordingClient struct {
+	sarama.Client
+	closed bool
+}
+
+func (c *closeRecordingClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestKafka_Shutdown(t *testing.T) {
+	k := &kafka{consumer: Consumer{Logger: logger, ShutdownTimeout: time.Second}}
+	client := &closeRecordingClient{}
+	group := &fakeConsumerGroup{}
+	stopped := make(chan struct{})
+	cancel := func() { close(stopped) }
+
+	err := k.shutdown(client, group, cancel, stopped)
+	assert.NoError(t, err)
+	assert.True(t, group.closed)
+	assert.True(t, client.closed)
+}
+
+func TestKafka_ShutdownTimeout(t *testing.T) {
+	k := &kafka{consumer: Consumer{Logger: logger, ShutdownTimeout: 10 * time.Millisecond}}
+	client := &closeRecordingClient{}
+	group := &fakeConsumerGroup{}
+	// the session never ends, e.g. a worker is stuck retrying its batch
+	stopped := make(chan struct{})
+	cancel := func() {}
+
+	err := k.shutdown(client, group, cancel, stopped)
+	assert.Equal(t, ErrShutdownTimeout, err)
+	assert.False(t, group.closed)
+	assert.False(t, client.closed)
+}
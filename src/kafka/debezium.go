@@ -0,0 +1,189 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	e "github.com/inloco/kafka-elasticsearch-injector/src/errors"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// CDCModeDebezium is the change data capture mode for Debezium change events.
+const CDCModeDebezium = "debezium"
+
+var (
+	errMissingChangeEventKey = errors.New("change event has no key")
+	errEmptyChangeEventKey   = errors.New("change event key has no primary key columns")
+)
+
+// DebeziumDeserializerFor decodes Debezium change events, whose envelope and
+// key are encoded as recordType. Documents are the "after" image of the row,
+// identified by its primary key, from the message key. Deletes and tombstones
+// become deletions of the document. The position of the change in the
+// database log, or its timestamp, is used as document version so replays
+// don't overwrite newer changes. Since primary keys and log positions are
+// often integers above 2^53, JSON numbers are decoded as json.Number.
+func (d *Decoder) DebeziumDeserializerFor(recordType string) DecodeMessageFunc {
+	d.jsonNumbers = true
+	decodeValue := d.DeserializerFor(recordType)
+	decodeKey := d.keyDecoderFor(recordType)
+	return func(ctx context.Context, msg *sarama.ConsumerMessage, includeKey bool) (*models.Record, error) {
+		if msg.Key == nil {
+			if msg.Value == nil {
+				return nil, e.ErrNilMessage
+			}
+			return nil, errMissingChangeEventKey
+		}
+		key, err := decodeKey(msg.Key)
+		if err != nil {
+			return nil, err
+		}
+		keyFields, ok := debeziumPayload(key).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("change event key should be a struct, got %T", key)
+		}
+		if len(keyFields) == 0 {
+			// documents of tables without primary key can't be kept up to date
+			return nil, errEmptyChangeEventKey
+		}
+		id := debeziumDocumentID(keyFields)
+
+		if msg.Value == nil {
+			// tombstone, sent after delete events for log compaction
+			record := d.newRecord(msg, debeziumDocument(keyFields, msg, includeKey, key))
+			record.ID = id
+			record.Delete = true
+			return record, nil
+		}
+
+		decoded, err := decodeValue(ctx, msg, false)
+		if err != nil {
+			return nil, err
+		}
+		envelope, ok := debeziumPayload(decoded.Json).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("change event value should be a struct, got %T", decoded.Json)
+		}
+		op, _ := envelope["op"].(string)
+		image := "after"
+		switch op {
+		case "c", "r", "u":
+		case "d":
+			image = "before"
+		default:
+			// truncates and logical decoding messages don't map to documents
+			return nil, e.ErrSkippedMessage
+		}
+		row, _ := envelope[image].(map[string]interface{})
+		if row == nil {
+			// the before image of deletes may be missing, depending on the
+			// replica identity of the table
+			row = keyFields
+		}
+
+		record := d.newRecord(msg, debeziumDocument(row, msg, includeKey, key))
+		record.ID = id
+		record.Delete = op == "d"
+		record.Version = debeziumVersion(envelope)
+		return record, nil
+	}
+}
+
+// keyDecoderFor returns the decoder of keys of records of recordType.
+func (d *Decoder) keyDecoderFor(recordType string) func([]byte) (interface{}, error) {
+	var valueFormat func([]byte) (interface{}, error)
+	switch recordType {
+	case "json":
+		valueFormat = d.jsonObjectFromBinary
+	case "protobuf":
+		valueFormat = func(key []byte) (interface{}, error) {
+			return d.mapFromProtobuf(key)
+		}
+	case "jsonschema":
+		valueFormat = func(key []byte) (interface{}, error) {
+			return d.mapFromJsonSchema(key)
+		}
+	default:
+		valueFormat = d.nativeFromBinary
+	}
+	return func(key []byte) (interface{}, error) {
+		return d.decodeKey(key, valueFormat)
+	}
+}
+
+func debeziumDocument(row map[string]interface{}, msg *sarama.ConsumerMessage, includeKey bool, key interface{}) map[string]interface{} {
+	document := make(map[string]interface{}, len(row)+2)
+	for field, value := range row {
+		document[field] = value
+	}
+	document[kafkaTimestampKey] = makeTimestamp(msg.Timestamp)
+	if includeKey {
+		document[keyField] = key
+	}
+	return document
+}
+
+// debeziumPayload unwraps values written by the JSON converter with schemas
+// enabled, which wraps them in a {"schema": ..., "payload": ...} object.
+func debeziumPayload(value interface{}) interface{} {
+	wrapped, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	if _, hasSchema := wrapped["schema"]; hasSchema {
+		if payload, hasPayload := wrapped["payload"]; hasPayload {
+			return payload
+		}
+	}
+	return value
+}
+
+// debeziumDocumentID joins the primary key columns values, sorted by name.
+func debeziumDocumentID(key map[string]interface{}) string {
+	names := make([]string, 0, len(key))
+	for name := range key {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = formatKey(key[name])
+	}
+	return strings.Join(values, ":")
+}
+
+// debeziumVersion is the log sequence number of the change, for connectors
+// that have one, or the time the connector processed it.
+func debeziumVersion(envelope map[string]interface{}) *int64 {
+	source, _ := envelope["source"].(map[string]interface{})
+	for _, version := range []interface{}{source["lsn"], source["ts_ms"], envelope["ts_ms"]} {
+		if v, ok := toInt64(version); ok {
+			return &v
+		}
+	}
+	return nil
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case json.Number:
+		i, err := strconv.ParseInt(v.String(), 10, 64)
+		return i, err == nil
+	case string: // protobuf int64 values are rendered as strings
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	e "github.com/inloco/kafka-elasticsearch-injector/src/errors"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeChangeEvent(key, value []byte) (*models.Record, error) {
+	d := &Decoder{}
+	return d.DebeziumDeserializerFor("json")(context.Background(), &sarama.ConsumerMessage{
+		Key:       key,
+		Value:     value,
+		Topic:     "dbserver.public.users",
+		Offset:    7,
+		Timestamp: time.Now(),
+	}, false)
+}
+
+func TestDecoder_Debezium_Update(t *testing.T) {
+	record, err := decodeChangeEvent(
+		[]byte(`{"id": 42}`),
+		[]byte(`{"before": {"id": 42, "name": "old"}, "after": {"id": 42, "name": "new"}, "op": "u", "source": {"lsn": 1234, "ts_ms": 99}, "ts_ms": 100}`),
+	)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "42", record.ID)
+		assert.False(t, record.Delete)
+		if assert.NotNil(t, record.Version) {
+			assert.Equal(t, int64(1234), *record.Version)
+		}
+		assert.Equal(t, "new", record.Json["name"])
+		assert.NotContains(t, record.Json, "before")
+		assert.Contains(t, record.Json, kafkaTimestampKey)
+	}
+}
+
+func TestDecoder_Debezium_Delete(t *testing.T) {
+	record, err := decodeChangeEvent(
+		[]byte(`{"schema": {}, "payload": {"tenant": "acme", "id": 42}}`),
+		[]byte(`{"schema": {}, "payload": {"before": {"tenant": "acme", "id": 42, "name": "old"}, "after": null, "op": "d", "source": {"ts_ms": 99}}}`),
+	)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "42:acme", record.ID)
+		assert.True(t, record.Delete)
+		if assert.NotNil(t, record.Version) {
+			assert.Equal(t, int64(99), *record.Version)
+		}
+		assert.Equal(t, "old", record.Json["name"])
+	}
+}
+
+func TestDecoder_Debezium_Tombstone(t *testing.T) {
+	record, err := decodeChangeEvent([]byte(`{"id": 42}`), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "42", record.ID)
+		assert.True(t, record.Delete)
+		assert.Nil(t, record.Version)
+	}
+}
+
+func TestDecoder_Debezium_JsonNumberKeys(t *testing.T) {
+	record, err := decodeChangeEvent(
+		[]byte(`{"id": 10000000, "account": 12345678901, "ratio": 0.5}`),
+		[]byte(`{"after": {"id": 10000000, "account": 12345678901}, "op": "c", "source": {"lsn": 9007199254740993}}`),
+	)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "12345678901:10000000:0.5", record.ID)
+		if assert.NotNil(t, record.Version) {
+			assert.Equal(t, int64(9007199254740993), *record.Version)
+		}
+	}
+}
+
+func TestDecoder_Debezium_EmptyKey(t *testing.T) {
+	for _, key := range []string{`null`, `{}`} {
+		_, err := decodeChangeEvent([]byte(key), []byte(`{"after": {"name": "new"}, "op": "c", "source": {"ts_ms": 99}}`))
+		assert.Equal(t, errEmptyChangeEventKey, err, key)
+	}
+}
+
+func TestDecoder_Debezium_Truncate(t *testing.T) {
+	_, err := decodeChangeEvent([]byte(`{"id": 42}`), []byte(`{"op": "t", "source": {"ts_ms": 99}}`))
+	assert.True(t, errors.Is(err, e.ErrSkippedMessage))
+}
+
+func TestDecoder_Debezium_MissingKey(t *testing.T) {
+	_, err := decodeChangeEvent(nil, []byte(`{"after": {"id": 42}, "op": "c"}`))
+	assert.Equal(t, errMissingChangeEventKey, err)
+
+	_, err = decodeChangeEvent(nil, nil)
+	assert.True(t, errors.Is(err, e.ErrNilMessage))
+}
@@ -1,8 +1,10 @@
 package kafka
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"reflect"
 	"time"
 
@@ -26,17 +28,50 @@ type DecodeMessageFunc func(context.Context, *sarama.ConsumerMessage, bool) (rec
 const kafkaTimestampKey = "@timestamp"
 const keyField = "key"
 
+var errInvalidAvroWireFormat = errors.New("value is not in the schema registry avro wire format")
+
 type Decoder struct {
-	SchemaRegistry *schema_registry.SchemaRegistry
-	CodecCache     sync.Map
+	SchemaRegistry  *schema_registry.SchemaRegistry
+	CodecCache      sync.Map
+	AvroSchemaCache sync.Map
+	DescriptorCache sync.Map
+	JsonSchemaCache sync.Map
+	ValidateSchema  bool
+	// DecimalFormat and TimestampFormat set how Avro logical types are
+	// rendered, strings and ISO-8601 dates by default.
+	DecimalFormat   string
+	TimestampFormat string
+	// KeyFormat is the format of record keys, see decodeKey.
+	KeyFormat string
+	// MetadataField is the field where the Kafka message metadata is added,
+	// with only the MetadataHeaders headers if any is set. Metadata is not
+	// added if empty.
+	MetadataField   string
+	MetadataHeaders []string
+	// DeleteOnTombstone turns messages without value into deletions of the
+	// document identified by their key, instead of skipping them.
+	DeleteOnTombstone bool
+	// jsonNumbers decodes JSON numbers as json.Number instead of float64, see
+	// DebeziumDeserializerFor.
+	jsonNumbers bool
 }
 
 func (d *Decoder) DeserializerFor(recordType string) DecodeMessageFunc {
-	if recordType == "json" {
-		return d.JsonMessageToRecord
-	} else {
-		return d.AvroMessageToRecord
+	var decode DecodeMessageFunc
+	switch recordType {
+	case "json":
+		decode = d.JsonMessageToRecord
+	case "protobuf":
+		decode = d.ProtobufMessageToRecord
+	case "jsonschema":
+		decode = d.JsonSchemaMessageToRecord
+	default:
+		decode = d.AvroMessageToRecord
+	}
+	if d.DeleteOnTombstone {
+		return d.deleteOnTombstone(decode, d.keyDecoderFor(recordType))
 	}
+	return decode
 }
 
 func (d *Decoder) AvroMessageToRecord(context context.Context, msg *sarama.ConsumerMessage, includeKey bool) (*models.Record, error) {
@@ -64,20 +99,14 @@ func (d *Decoder) AvroMessageToRecord(context context.Context, msg *sarama.Consu
 	parsedNative[kafkaTimestampKey] = makeTimestamp(msg.Timestamp)
 
 	if includeKey && msg.Key != nil {
-		nativeKey, err := d.nativeFromBinary(msg.Key)
+		nativeKey, err := d.decodeKey(msg.Key, d.nativeFromBinary)
 		if err != nil {
 			return nil, err
 		}
 		parsedNative[keyField] = nativeKey
 	}
 
-	return &models.Record{
-		Topic:     msg.Topic,
-		Partition: msg.Partition,
-		Offset:    msg.Offset,
-		Timestamp: msg.Timestamp,
-		Json:      parsedNative,
-	}, nil
+	return d.newRecord(msg, parsedNative), nil
 }
 
 func makeTimestamp(timestamp time.Time) int64 {
@@ -85,9 +114,12 @@ func makeTimestamp(timestamp time.Time) int64 {
 }
 
 func (d *Decoder) JsonMessageToRecord(context context.Context, msg *sarama.ConsumerMessage, includeKey bool) (*models.Record, error) {
+	if msg.Value == nil {
+		return nil, e.ErrNilMessage
+	}
+
 	var jsonValue map[string]interface{}
-	var jsonKey map[string]interface{}
-	err := json.Unmarshal(msg.Value, &jsonValue)
+	err := d.unmarshalJson(msg.Value, &jsonValue)
 
 	if err != nil {
 		return nil, err
@@ -96,23 +128,45 @@ func (d *Decoder) JsonMessageToRecord(context context.Context, msg *sarama.Consu
 	jsonValue[kafkaTimestampKey] = makeTimestamp(msg.Timestamp)
 
 	if includeKey && msg.Key != nil {
-		err := json.Unmarshal(msg.Key, &jsonKey)
+		jsonKey, err := d.decodeKey(msg.Key, d.jsonObjectFromBinary)
 		if err != nil {
 			return nil, err
 		}
 		jsonValue[keyField] = jsonKey
 	}
 
-	return &models.Record{
-		Topic:     msg.Topic,
-		Partition: msg.Partition,
-		Offset:    msg.Offset,
-		Timestamp: msg.Timestamp,
-		Json:      jsonValue,
-	}, nil
+	return d.newRecord(msg, jsonValue), nil
+}
+
+func (d *Decoder) jsonObjectFromBinary(value []byte) (interface{}, error) {
+	var jsonObject map[string]interface{}
+	if err := d.unmarshalJson(value, &jsonObject); err != nil {
+		return nil, err
+	}
+	return jsonObject, nil
+}
+
+// unmarshalJson decodes JSON, keeping numbers as json.Number when the decoder
+// has jsonNumbers set, so integers above 2^53 keep their precision.
+func (d *Decoder) unmarshalJson(data []byte, v interface{}) error {
+	if !d.jsonNumbers {
+		return json.Unmarshal(data, v)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level JSON value")
+	}
+	return nil
 }
 
 func (d *Decoder) nativeFromBinary(value []byte) (interface{}, error) {
+	if len(value) < 5 || value[0] != 0 {
+		return nil, errInvalidAvroWireFormat
+	}
 	schemaId := getSchemaId(value)
 	avroRecord := value[5:]
 	schema, err := d.SchemaRegistry.GetSchema(schemaId)
@@ -138,7 +192,26 @@ func (d *Decoder) nativeFromBinary(value []byte) (interface{}, error) {
 		return nil, err
 	}
 
-	return native, nil
+	parsed, err := d.avroSchema(schemaId, codec)
+	if err != nil {
+		return nil, err
+	}
+	normalizer := avroNormalizer{decimalFormat: d.DecimalFormat, timestampFormat: d.TimestampFormat}
+	return normalizer.normalize(parsed, parsed.root, "", native), nil
+}
+
+func (d *Decoder) avroSchema(schemaId int32, codec *goavro.Codec) (*avroSchema, error) {
+	if schemaI, ok := d.AvroSchemaCache.Load(schemaId); ok {
+		if schema, ok := schemaI.(*avroSchema); ok {
+			return schema, nil
+		}
+	}
+	schema, err := parseAvroSchema(codec.Schema())
+	if err != nil {
+		return nil, err
+	}
+	d.AvroSchemaCache.Store(schemaId, schema)
+	return schema, nil
 }
 
 func getSchemaId(value []byte) int32 {
@@ -91,6 +91,19 @@ func TestDecoder_AvroMessageToRecord_NilMessageValue_IncludeKey(t *testing.T) {
 	assert.True(t, isErrNilMessage)
 }
 
+func TestDecoder_JsonMessageToRecord_NilMessageValue(t *testing.T) {
+	d := &Decoder{}
+	record, err := d.JsonMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     nil,
+		Key:       []byte(`{"id": 1}`),
+		Topic:     "test",
+		Partition: 1, Offset: 54,
+		Timestamp: time.Now()},
+		true)
+	assert.Nil(t, record)
+	assert.True(t, errors.Is(err, e.ErrNilMessage))
+}
+
 func TestDecoder_JsonMessageToRecord_IncludeKey(t *testing.T) {
 	d := &Decoder{CodecCache: sync.Map{}}
 
@@ -125,3 +138,158 @@ func TestDecoder_JsonMessageToRecord_IncludeKey(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expected, returnedKeyIncluded)
 }
+
+func TestDecoder_JsonMessageToRecord_KeyFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		key      []byte
+		expected interface{}
+	}{
+		{KeyFormatString, []byte("user-1"), "user-1"},
+		{KeyFormatBytesBase64, []byte{0xff, 0x00, 0x01}, "/wAB"},
+		{KeyFormatJson, []byte(`"user-1"`), "user-1"},
+		{KeyFormatLong, []byte{0, 0, 0, 0, 0, 0, 1, 0}, int64(256)},
+		{"", []byte(`{"id": "user-1"}`), map[string]interface{}{"id": "user-1"}},
+	}
+	for _, tt := range tests {
+		d := &Decoder{KeyFormat: tt.format}
+		record, err := d.JsonMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+			Key:       tt.key,
+			Value:     []byte(`{"id": "pop"}`),
+			Topic:     "test",
+			Timestamp: time.Now(),
+		}, true)
+		if assert.NoError(t, err, tt.format) {
+			assert.Equal(t, tt.expected, record.Json[keyField], tt.format)
+		}
+	}
+}
+
+func TestDecoder_JsonMessageToRecord_InvalidLongKey(t *testing.T) {
+	d := &Decoder{KeyFormat: KeyFormatLong}
+	_, err := d.JsonMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Key:       []byte("user-1"),
+		Value:     []byte(`{"id": "pop"}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, true)
+	assert.Error(t, err)
+}
+
+func TestDecoder_AvroMessageToRecord_StringKeyWithoutKeyFormat(t *testing.T) {
+	d := &Decoder{}
+	_, err := d.decodeKey([]byte("abc"), d.nativeFromBinary)
+	assert.Equal(t, errInvalidAvroWireFormat, err)
+}
+
+func TestDecoder_JsonMessageToRecord_Metadata(t *testing.T) {
+	d := &Decoder{MetadataField: "kafka", MetadataHeaders: []string{"tenant"}}
+	timestamp := time.Now()
+	record, err := d.JsonMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     []byte(`{"id": "pop"}`),
+		Topic:     "test",
+		Partition: 1,
+		Offset:    54,
+		Timestamp: timestamp,
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("tenant"), Value: []byte("acme")},
+			{Key: []byte("trace"), Value: []byte("abc")},
+		},
+	}, false)
+	if assert.NoError(t, err) {
+		expected := map[string]interface{}{
+			"topic":     "test",
+			"partition": int32(1),
+			"offset":    int64(54),
+			"timestamp": makeTimestamp(timestamp),
+			"headers":   map[string]interface{}{"tenant": "acme"},
+		}
+		assert.Equal(t, expected, record.Metadata)
+		assert.Equal(t, expected, record.Json["kafka"])
+		tenant, err := record.GetValueForField("@metadata.headers.tenant")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "acme", tenant)
+		}
+	}
+}
+
+func TestDecoder_JsonMessageToRecord_WithoutMetadata(t *testing.T) {
+	d := &Decoder{}
+	record, err := d.JsonMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     []byte(`{"id": "pop"}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		assert.Nil(t, record.Metadata)
+		assert.NotContains(t, record.Json, "kafka")
+	}
+}
+
+func TestDecoder_DeleteOnTombstone(t *testing.T) {
+	d := &Decoder{DeleteOnTombstone: true, KeyFormat: KeyFormatString}
+	record, err := d.DeserializerFor("json")(context.Background(), &sarama.ConsumerMessage{
+		Key:       []byte("user-1"),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		assert.True(t, record.Delete)
+		assert.Equal(t, "user-1", record.ID)
+	}
+}
+
+func TestDecoder_DeleteOnTombstone_StructKey(t *testing.T) {
+	d := &Decoder{DeleteOnTombstone: true}
+	record, err := d.DeserializerFor("json")(context.Background(), &sarama.ConsumerMessage{
+		Key:       []byte(`{"user_id": "user-1"}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		assert.True(t, record.Delete)
+		assert.Empty(t, record.ID)
+		id, err := record.GetValueForField("user_id")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "user-1", id)
+		}
+	}
+}
+
+func TestDecoder_DeleteOnTombstone_NumericJsonKey(t *testing.T) {
+	d := &Decoder{DeleteOnTombstone: true, KeyFormat: KeyFormatJson}
+	decode := d.DeserializerFor("json")
+	record, err := decode(context.Background(), &sarama.ConsumerMessage{
+		Key:       []byte(`12345678901`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		assert.True(t, record.Delete)
+		assert.Equal(t, "12345678901", record.ID)
+	}
+
+	// the ID of the upsert, picked from the value by the codec, is the same
+	upsert, err := decode(context.Background(), &sarama.ConsumerMessage{
+		Key:       []byte(`12345678901`),
+		Value:     []byte(`{"user_id": 12345678901}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		id, err := upsert.GetValueForField("user_id")
+		if assert.NoError(t, err) {
+			assert.Equal(t, record.ID, id)
+		}
+	}
+}
+
+func TestDecoder_DeleteOnTombstone_Disabled(t *testing.T) {
+	d := &Decoder{}
+	_, err := d.DeserializerFor("avro")(context.Background(), &sarama.ConsumerMessage{
+		Key:       []byte("user-1"),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	assert.True(t, errors.Is(err, e.ErrNilMessage))
+}
@@ -0,0 +1,428 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// Filter selects the records to send to the endpoint with a boolean
+// expression over their fields, e.g. `event_type == "purchase" && amount > 10`.
+//
+// Expressions compare field paths, as in models.Record.LookupField, and
+// literals (strings in single or double quotes, numbers, true, false and null)
+// with ==, !=, <, <=, > and >=, combined with &&, || and ! and grouped with
+// parentheses. Missing fields are null, and fields alone are true unless they
+// are null, false, zero or empty strings. String fields holding integers are
+// compared to numbers as numbers, see integerString.
+type Filter struct {
+	expression string
+	eval       filterExpr
+}
+
+type filterExpr func(record *models.Record) interface{}
+
+func ParseFilter(expression string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expression, err)
+	}
+	p := &filterParser{tokens: tokens}
+	eval, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expression, err)
+	}
+	return &Filter{expression: expression, eval: eval}, nil
+}
+
+func (f *Filter) String() string {
+	return f.expression
+}
+
+// Match tells whether record satisfies the filter expression.
+func (f *Filter) Match(record *models.Record) bool {
+	return truthy(f.eval(record))
+}
+
+type filterTokenKind int
+
+const (
+	tokenOperator filterTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenField
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '"' || c == '\'':
+			value, n, err := scanFilterString(expression[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, filterToken{tokenString, value})
+			i += n
+			continue
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(expression) && expression[i+1] >= '0' && expression[i+1] <= '9':
+			end := i + 1
+			for end < len(expression) && strings.IndexByte("0123456789.eE+-", expression[end]) >= 0 {
+				end++
+			}
+			tokens = append(tokens, filterToken{tokenNumber, expression[i:end]})
+			i = end
+			continue
+		}
+		operator := ""
+		for _, op := range filterOperators {
+			if strings.HasPrefix(expression[i:], op) {
+				operator = op
+				break
+			}
+		}
+		if operator != "" {
+			tokens = append(tokens, filterToken{tokenOperator, operator})
+			i += len(operator)
+			continue
+		}
+		end, err := scanFilterField(expression, i)
+		if err != nil {
+			return nil, err
+		}
+		if end == i {
+			return nil, fmt.Errorf("unexpected %q", string(c))
+		}
+		tokens = append(tokens, filterToken{tokenField, expression[i:end]})
+		i = end
+	}
+	return tokens, nil
+}
+
+// scanFilterString scans a quoted string, returning its value and length.
+func scanFilterString(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case s[0]:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unclosed string %s", s)
+}
+
+// scanFilterField returns the end of the field path starting at start, which
+// can have brackets with quoted keys, e.g. items[0]['user.name'].
+func scanFilterField(expression string, start int) (int, error) {
+	i := start
+	for i < len(expression) {
+		c := expression[i]
+		if c == '[' {
+			end := strings.IndexByte(expression[i:], ']')
+			if end < 0 {
+				return 0, fmt.Errorf("unclosed [ in %s", expression[start:])
+			}
+			i += end + 1
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || strings.IndexByte("&|=!<>()\"'", c) >= 0 {
+			break
+		}
+		i++
+	}
+	return i, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) accept(operators ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range operators {
+		if p.tokens[p.pos].text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record *models.Record) interface{} {
+			return truthy(l(record)) || truthy(right(record))
+		}
+	}
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record *models.Record) interface{} {
+			return truthy(l(record)) && truthy(right(record))
+		}
+	}
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if _, ok := p.accept("!"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(record *models.Record) interface{} {
+			return !truthy(operand(record))
+		}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(record *models.Record) interface{} {
+		return compareFilterValues(op, left(record), right(record))
+	}, nil
+}
+
+func (p *filterParser) parseOperand() (filterExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if _, ok := p.accept("("); ok {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expr, nil
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	var value interface{}
+	switch token.kind {
+	case tokenString:
+		value = token.text
+	case tokenNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", token.text)
+		}
+		value = number
+	case tokenField:
+		switch token.text {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			if !strings.HasPrefix(token.text, models.MetadataPrefix) {
+				if _, err := models.ParseFieldPath(token.text); err != nil {
+					return nil, err
+				}
+			}
+			field := token.text
+			return func(record *models.Record) interface{} {
+				value, _ := record.LookupField(field)
+				return normalizeFilterValue(value)
+			}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected %q", token.text)
+	}
+	return func(*models.Record) interface{} { return value }, nil
+}
+
+// integerString is a string field holding an integer, such as the protobuf
+// int64 fields the protobuf JSON mapping renders as strings. It's compared to
+// numbers as a number, and to anything else as a string.
+type integerString struct {
+	text   string
+	number float64
+}
+
+// normalizeFilterValue converts numbers to float64 and timestamps to RFC 3339
+// strings, so they can be compared to literals.
+func normalizeFilterValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return integerString{text: v, number: float64(i)}
+		}
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+// compareFilterValues compares numbers, strings, booleans and nulls. Values of
+// different types are never equal, nor ordered.
+func compareFilterValues(op string, left, right interface{}) bool {
+	left, right = resolveIntegerString(left, right), resolveIntegerString(right, left)
+	cmp, comparable := 0, false
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			comparable = true
+			switch {
+			case l < r:
+				cmp = -1
+			case l > r:
+				cmp = 1
+			}
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			comparable = true
+			cmp = strings.Compare(l, r)
+		}
+	case bool:
+		if r, ok := right.(bool); ok && (op == "==" || op == "!=") {
+			return (l == r) == (op == "==")
+		}
+	case nil:
+		if op == "==" || op == "!=" {
+			return (right == nil) == (op == "==")
+		}
+	}
+	if !comparable {
+		return op == "!="
+	}
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// resolveIntegerString returns the number of an integerString value compared
+// to a number, and its text otherwise.
+func resolveIntegerString(value, other interface{}) interface{} {
+	s, ok := value.(integerString)
+	if !ok {
+		return value
+	}
+	if _, number := other.(float64); number {
+		return s.number
+	}
+	return s.text
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case integerString:
+		return v.text != ""
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+func filterRecord() *models.Record {
+	return &models.Record{
+		Topic: "events",
+		Json: map[string]interface{}{
+			"event_type": "purchase",
+			"amount":     int32(15),
+			"price":      json.Number("9.5"),
+			"paid":       true,
+			"created_at": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			"user":       map[string]interface{}{"name": "O'Brien", "tags": []interface{}{"vip"}},
+			"note":       "",
+			"quantity":   uint16(3),
+			"account_id": "9007199254740993", // protobuf int64
+		},
+		Metadata: map[string]interface{}{models.MetadataTopic: "events"},
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{`event_type == "purchase" && amount > 10`, true},
+		{`event_type == 'purchase' && amount > 20`, false},
+		{`event_type != "purchase" || amount >= 15`, true},
+		{`!(event_type == "refund") && price < 10`, true},
+		{`price <= 9.5 && price > -1`, true},
+		{`paid == true && paid != false`, true},
+		{`paid`, true},
+		{`note`, false},
+		{`!missing`, true},
+		{`missing == null && event_type != null`, true},
+		{`missing > 1 || missing < 1`, false},
+		{`amount == "15"`, false},
+		{`amount != "15"`, true},
+		{`created_at >= "2020-01-01" && created_at < "2020-02-01"`, true},
+		{`user.name == "O\'Brien" && user.tags[0] == "vip"`, true},
+		{`$.user['name'] == "O'Brien"`, true},
+		{`@metadata.topic == "events"`, true},
+		{`event_type=="purchase"&&(amount<10||paid)`, true},
+		{`quantity == 3 && quantity < 4`, true},
+		{`account_id > 9007199254740000`, true},
+		{`account_id == "9007199254740993"`, true},
+		{`event_type > 1 || event_type < 1`, false},
+	}
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.expression)
+		if assert.NoError(t, err, tt.expression) {
+			assert.Equal(t, tt.expected, filter.Match(filterRecord()), tt.expression)
+		}
+	}
+}
+
+func TestParseFilter_Invalid(t *testing.T) {
+	for _, expression := range []string{
+		``, `amount >`, `(amount > 1`, `amount > 1)`, `amount 1`, `"unclosed`, `items[0`, `a..b == 1`, `amount > 1 &&`, `amount > 1 & paid`,
+	} {
+		_, err := ParseFilter(expression)
+		assert.Error(t, err, expression)
+	}
+}
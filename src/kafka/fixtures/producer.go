@@ -43,9 +43,9 @@ Config example :
 	config.Producer.Flush.Frequency = 500 * time.Millisecond
 	config.Version = sarama.V2_3_0_0
 */
-func NewProducer(kafkaAddr string, config *sarama.Config, schemaRegistry *schema_registry.SchemaRegistry) (Producer, error) {
+func NewProducer(brokers []string, config *sarama.Config, schemaRegistry *schema_registry.SchemaRegistry) (Producer, error) {
 	sarama.MaxRequestSize = 20 * 1024 * 1024 // 20mb
-	client, err := sarama.NewAsyncProducer([]string{kafkaAddr}, config)
+	client, err := sarama.NewAsyncProducer(brokers, config)
 	if err != nil {
 		return nil, err
 	}
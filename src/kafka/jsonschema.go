@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	e "github.com/inloco/kafka-elasticsearch-injector/src/errors"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var (
+	errInvalidJsonSchemaWireFormat = errors.New("value is not in the schema registry json schema wire format")
+	errNullJsonSchemaPayload       = errors.New("json schema payload is null")
+)
+
+// jsonSchemaBaseURL is where registry schemas are loaded, references must
+// have canonical URLs to be resolved by name from the referencing schema.
+const jsonSchemaBaseURL = "http://schema-registry/"
+
+// JsonSchemaMessageToRecord decodes messages produced by Confluent's JSON
+// Schema serializer: a magic byte, the schema id and then the JSON payload.
+// When ValidateSchema is set, payloads are validated against the registered
+// schema and invalid ones fail with ErrSchemaValidation.
+func (d *Decoder) JsonSchemaMessageToRecord(context context.Context, msg *sarama.ConsumerMessage, includeKey bool) (*models.Record, error) {
+	if msg.Value == nil {
+		return nil, e.ErrNilMessage
+	}
+
+	value, err := d.mapFromJsonSchema(msg.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	value[kafkaTimestampKey] = makeTimestamp(msg.Timestamp)
+
+	if includeKey && msg.Key != nil {
+		key, err := d.decodeKey(msg.Key, func(key []byte) (interface{}, error) {
+			return d.mapFromJsonSchema(key)
+		})
+		if err != nil {
+			return nil, err
+		}
+		value[keyField] = key
+	}
+
+	return d.newRecord(msg, value), nil
+}
+
+func (d *Decoder) mapFromJsonSchema(value []byte) (map[string]interface{}, error) {
+	if len(value) < 5 || value[0] != 0 {
+		return nil, errInvalidJsonSchemaWireFormat
+	}
+	schemaId := getSchemaId(value)
+	payload := value[5:]
+
+	if d.ValidateSchema {
+		if err := d.validateJsonSchema(schemaId, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	var parsed map[string]interface{}
+	if err := d.unmarshalJson(payload, &parsed); err != nil {
+		return nil, err
+	}
+	// null is valid JSON, and may be valid against the schema too, but there
+	// is no document to build from it
+	if parsed == nil {
+		return nil, errNullJsonSchemaPayload
+	}
+	return parsed, nil
+}
+
+func (d *Decoder) validateJsonSchema(schemaId int32, payload []byte) error {
+	schema, err := d.jsonSchema(schemaId)
+	if err != nil {
+		return err
+	}
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(payload))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	reasons := make([]string, len(result.Errors()))
+	for i, resultErr := range result.Errors() {
+		reasons[i] = resultErr.String()
+	}
+	return fmt.Errorf("%w: schema %d: %s", e.ErrSchemaValidation, schemaId, strings.Join(reasons, "; "))
+}
+
+func (d *Decoder) jsonSchema(schemaId int32) (*gojsonschema.Schema, error) {
+	if schemaI, ok := d.JsonSchemaCache.Load(schemaId); ok {
+		if schema, ok := schemaI.(*gojsonschema.Schema); ok {
+			return schema, nil
+		}
+	}
+
+	resolved, err := d.SchemaRegistry.GetSchemaWithReferences(schemaId)
+	if err != nil {
+		return nil, err
+	}
+	loader := gojsonschema.NewSchemaLoader()
+	for name, reference := range resolved.References {
+		if err := loader.AddSchema(jsonSchemaBaseURL+name, gojsonschema.NewStringLoader(reference)); err != nil {
+			return nil, err
+		}
+	}
+	rootURL := fmt.Sprintf("%sschema-%d.json", jsonSchemaBaseURL, schemaId)
+	if err := loader.AddSchema(rootURL, gojsonschema.NewStringLoader(resolved.Schema)); err != nil {
+		return nil, err
+	}
+	schema, err := loader.Compile(gojsonschema.NewReferenceLoader(rootURL))
+	if err != nil {
+		return nil, err
+	}
+
+	d.JsonSchemaCache.Store(schemaId, schema)
+	return schema, nil
+}
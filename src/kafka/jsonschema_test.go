@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	e "github.com/inloco/kafka-elasticsearch-injector/src/errors"
+	"github.com/inloco/kafka-elasticsearch-injector/src/schema_registry"
+	"github.com/stretchr/testify/assert"
+)
+
+const tenantJsonSchema = `{
+  "type": "object",
+  "properties": {"id": {"type": "string"}},
+  "required": ["id"]
+}`
+
+const purchaseJsonSchema = `{
+  "type": "object",
+  "properties": {
+    "id": {"type": "string"},
+    "amount": {"type": "number"},
+    "tenant": {"$ref": "tenant.json"}
+  },
+  "required": ["id", "amount"]
+}`
+
+func newJsonSchemaRegistry(t *testing.T) (*schema_registry.SchemaRegistry, func()) {
+	tenant := map[string]interface{}{"schema": tenantJsonSchema, "schemaType": "JSON"}
+	return newTestRegistry(t, map[string]interface{}{
+		"/schemas/ids/3": map[string]interface{}{
+			"schema":     purchaseJsonSchema,
+			"schemaType": "JSON",
+			"references": []map[string]interface{}{{"name": "tenant.json", "subject": "tenant", "version": 1}},
+		},
+		"/schemas/ids/4":              tenant,
+		"/subjects/tenant/versions/1": tenant,
+	})
+}
+
+func jsonSchemaValue(schemaId byte, payload string) []byte {
+	return append([]byte{0, 0, 0, 0, schemaId}, payload...) // magic byte and schema id
+}
+
+func TestDecoder_JsonSchemaMessageToRecord(t *testing.T) {
+	registry, closeRegistry := newJsonSchemaRegistry(t)
+	defer closeRegistry()
+	d := &Decoder{SchemaRegistry: registry, ValidateSchema: true}
+	timestamp := time.Now()
+
+	record, err := d.JsonSchemaMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     jsonSchemaValue(3, `{"id": "p-1", "amount": 10.5, "tenant": {"id": "acme"}}`),
+		Key:       jsonSchemaValue(4, `{"id": "acme"}`),
+		Topic:     "test",
+		Partition: 1,
+		Offset:    54,
+		Timestamp: timestamp,
+	}, true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "p-1", record.Json["id"])
+		assert.Equal(t, 10.5, record.Json["amount"])
+		assert.Equal(t, map[string]interface{}{"id": "acme"}, record.Json["tenant"])
+		assert.Equal(t, map[string]interface{}{"id": "acme"}, record.Json[keyField])
+		assert.Equal(t, makeTimestamp(timestamp), record.Json[kafkaTimestampKey])
+	}
+}
+
+func TestDecoder_JsonSchemaMessageToRecord_Invalid(t *testing.T) {
+	registry, closeRegistry := newJsonSchemaRegistry(t)
+	defer closeRegistry()
+	d := &Decoder{SchemaRegistry: registry, ValidateSchema: true}
+
+	_, err := d.JsonSchemaMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     jsonSchemaValue(3, `{"id": "p-1", "amount": "ten", "tenant": {}}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, e.ErrSchemaValidation))
+		assert.Contains(t, err.Error(), "amount")
+		assert.Contains(t, err.Error(), "tenant")
+	}
+}
+
+func TestDecoder_JsonSchemaMessageToRecord_WithoutValidation(t *testing.T) {
+	d := &Decoder{}
+
+	record, err := d.JsonSchemaMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     jsonSchemaValue(3, `{"id": "p-1", "amount": "ten"}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ten", record.Json["amount"])
+	}
+}
+
+func TestDecoder_JsonSchemaMessageToRecord_InvalidWireFormat(t *testing.T) {
+	d := &Decoder{}
+	_, err := d.JsonSchemaMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     []byte(`{"id": "p-1"}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	assert.Equal(t, errInvalidJsonSchemaWireFormat, err)
+}
+
+func TestDecoder_JsonSchemaMessageToRecord_Null(t *testing.T) {
+	d := &Decoder{}
+	_, err := d.JsonSchemaMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     jsonSchemaValue(3, `null`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	assert.Equal(t, errNullJsonSchemaPayload, err)
+
+	_, err = d.JsonSchemaMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Key:       jsonSchemaValue(4, `null`),
+		Value:     jsonSchemaValue(3, `{"id": "p-1", "amount": 10}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, true)
+	assert.True(t, errors.Is(err, errNullJsonSchemaPayload))
+}
@@ -0,0 +1,55 @@
+package kafka
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// Formats of Kafka record keys. When no key format is set, keys are decoded
+// with the same format as the record values.
+const (
+	KeyFormatString      = "string"
+	KeyFormatBytesBase64 = "bytes-base64"
+	KeyFormatAvro        = "avro"
+	KeyFormatJson        = "json"
+	KeyFormatLong        = "long"
+)
+
+// decodeKey decodes a record key with the decoder's KeyFormat, falling back
+// to valueFormat, the decoder of the record value format.
+func (d *Decoder) decodeKey(key []byte, valueFormat func([]byte) (interface{}, error)) (interface{}, error) {
+	switch d.KeyFormat {
+	case KeyFormatString:
+		return string(key), nil
+	case KeyFormatBytesBase64:
+		return base64.StdEncoding.EncodeToString(key), nil
+	case KeyFormatAvro:
+		return d.nativeFromBinary(key)
+	case KeyFormatJson:
+		var jsonKey interface{}
+		if err := d.unmarshalJson(key, &jsonKey); err != nil {
+			return nil, err
+		}
+		return jsonKey, nil
+	case KeyFormatLong:
+		// as written by Kafka's LongSerializer
+		if len(key) != 8 {
+			return nil, fmt.Errorf("long key should have 8 bytes, got %d", len(key))
+		}
+		return int64(binary.BigEndian.Uint64(key)), nil
+	default:
+		return valueFormat(key)
+	}
+}
+
+// formatKey formats scalar key values as document IDs, as the codec formats
+// the values of ES_DOC_ID_COLUMN.
+func formatKey(key interface{}) string {
+	if formatted, ok := models.FormatScalar(key); ok {
+		return formatted
+	}
+	return fmt.Sprint(key)
+}
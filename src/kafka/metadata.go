@@ -0,0 +1,136 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+const timestampTypeConfig = "message.timestamp.type"
+
+// newRecord builds the record decoded from msg. When the decoder has a
+// MetadataField, the message metadata is added to the record and to its
+// JSON, under that field.
+func (d *Decoder) newRecord(msg *sarama.ConsumerMessage, value map[string]interface{}) *models.Record {
+	record := &models.Record{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Json:      value,
+		Message:   msg,
+	}
+	if d.MetadataField != "" {
+		record.Metadata = d.metadata(msg)
+		value[d.MetadataField] = record.Metadata
+	}
+	return record
+}
+
+func (d *Decoder) metadata(msg *sarama.ConsumerMessage) map[string]interface{} {
+	headers := make(map[string]interface{})
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		name := string(h.Key)
+		if d.allowedHeader(name) {
+			headers[name] = string(h.Value)
+		}
+	}
+	return map[string]interface{}{
+		models.MetadataTopic:     msg.Topic,
+		models.MetadataPartition: msg.Partition,
+		models.MetadataOffset:    msg.Offset,
+		models.MetadataTimestamp: makeTimestamp(msg.Timestamp),
+		models.MetadataHeaders:   headers,
+	}
+}
+
+// allowedHeader tells whether a header is added to the metadata, every one
+// is when MetadataHeaders is empty.
+func (d *Decoder) allowedHeader(name string) bool {
+	if len(d.MetadataHeaders) == 0 {
+		return true
+	}
+	for _, allowed := range d.MetadataHeaders {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// timestampTypes resolves, once per topic, whether its record timestamps are
+// set by producers (CreateTime) or by brokers (LogAppendTime). Consumed
+// messages don't carry it, so it's read from the topic configuration when the
+// topics are subscribed to, instead of while records are flushed.
+type timestampTypes struct {
+	admin  sarama.ClusterAdmin
+	logger log.Logger
+	types  sync.Map
+}
+
+func newTimestampTypes(client sarama.Client, logger log.Logger) *timestampTypes {
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		level.Warn(logger).Log("message", "Failed to create cluster admin, timestamp types are not resolved", "err", err.Error())
+	}
+	return &timestampTypes{admin: admin, logger: logger}
+}
+
+// resolve reads the timestamp types of the topics not resolved yet.
+func (t *timestampTypes) resolve(topics []string) {
+	if t == nil || t.admin == nil {
+		return
+	}
+	for _, topic := range topics {
+		if _, ok := t.types.Load(topic); !ok {
+			t.types.Store(topic, t.describe(topic))
+		}
+	}
+}
+
+// get returns the resolved timestamp type of topic, or an empty string if it
+// wasn't resolved.
+func (t *timestampTypes) get(topic string) string {
+	if t == nil {
+		return ""
+	}
+	if timestampType, ok := t.types.Load(topic); ok {
+		return timestampType.(string)
+	}
+	return ""
+}
+
+func (t *timestampTypes) describe(topic string) string {
+	entries, err := t.admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        topic,
+		ConfigNames: []string{timestampTypeConfig},
+	})
+	timestampType := ""
+	if err != nil {
+		level.Warn(t.logger).Log("message", "Failed to describe topic timestamp type", "topic", topic, "err", err.Error())
+	}
+	for _, entry := range entries {
+		if entry.Name == timestampTypeConfig {
+			timestampType = entry.Value
+		}
+	}
+	return timestampType
+}
+
+// setTimestampType adds the timestamp type of the topic to the metadata of
+// records that have it.
+func (t *timestampTypes) setTimestampType(record *models.Record) {
+	if record.Metadata == nil {
+		return
+	}
+	if timestampType := t.get(record.Topic); timestampType != "" {
+		record.Metadata[models.MetadataTimestampType] = timestampType
+	}
+}
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampTypes_SetTimestampType(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("events", 0, broker.BrokerID()),
+		"DescribeConfigsRequest": sarama.NewMockWrapper(&sarama.DescribeConfigsResponse{
+			Resources: []*sarama.ResourceResponse{{
+				Type:    sarama.TopicResource,
+				Name:    "events",
+				Configs: []*sarama.ConfigEntry{{Name: timestampTypeConfig, Value: "LogAppendTime"}},
+			}},
+		}),
+	})
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_3_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	types := newTimestampTypes(client, logger)
+	record := &models.Record{Topic: "events", Metadata: map[string]interface{}{}}
+	// topics are only described when subscribed to, never while flushing
+	types.setTimestampType(record)
+	assert.NotContains(t, record.Metadata, models.MetadataTimestampType)
+
+	types.resolve([]string{"events"})
+	types.setTimestampType(record)
+	assert.Equal(t, "LogAppendTime", record.Metadata[models.MetadataTimestampType])
+
+	withoutMetadata := &models.Record{Topic: "events"}
+	types.setTimestampType(withoutMetadata)
+	assert.Nil(t, withoutMetadata.Metadata)
+}
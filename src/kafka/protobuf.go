@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/jsonpb"
+	e "github.com/inloco/kafka-elasticsearch-injector/src/errors"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+var errInvalidProtobufWireFormat = errors.New("value is not in the schema registry protobuf wire format")
+
+var protobufJSONMarshaler = &jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+
+// ProtobufMessageToRecord decodes messages produced by Confluent's Protobuf
+// serializer: a magic byte, the schema id, the indexes of the message type in
+// the schema and then the Protobuf encoded message.
+func (d *Decoder) ProtobufMessageToRecord(context context.Context, msg *sarama.ConsumerMessage, includeKey bool) (*models.Record, error) {
+	if msg.Value == nil {
+		return nil, e.ErrNilMessage
+	}
+
+	value, err := d.mapFromProtobuf(msg.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	value[kafkaTimestampKey] = makeTimestamp(msg.Timestamp)
+
+	if includeKey && msg.Key != nil {
+		key, err := d.decodeKey(msg.Key, func(key []byte) (interface{}, error) {
+			return d.mapFromProtobuf(key)
+		})
+		if err != nil {
+			return nil, err
+		}
+		value[keyField] = key
+	}
+
+	return d.newRecord(msg, value), nil
+}
+
+func (d *Decoder) mapFromProtobuf(value []byte) (map[string]interface{}, error) {
+	if len(value) < 6 || value[0] != 0 {
+		return nil, errInvalidProtobufWireFormat
+	}
+	schemaId := getSchemaId(value)
+	indexes, payload, err := readMessageIndexes(value[5:])
+	if err != nil {
+		return nil, err
+	}
+	md, err := d.messageDescriptor(schemaId, indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	message := dynamic.NewMessage(md)
+	if err := message.Unmarshal(payload); err != nil {
+		return nil, err
+	}
+	jsonBytes, err := message.MarshalJSONPB(protobufJSONMarshaler)
+	if err != nil {
+		return nil, err
+	}
+	var parsed map[string]interface{}
+	if err := d.unmarshalJson(jsonBytes, &parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// readMessageIndexes reads the path to the message type within the schema,
+// encoded as a count followed by the indexes, all zig-zag varints. A zero
+// count is a shortcut for the first message type of the schema.
+func readMessageIndexes(value []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(value)
+	if n <= 0 || count < 0 {
+		return nil, nil, errInvalidProtobufWireFormat
+	}
+	value = value[n:]
+	if count == 0 {
+		return []int{0}, value, nil
+	}
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, n := binary.Varint(value)
+		if n <= 0 {
+			return nil, nil, errInvalidProtobufWireFormat
+		}
+		indexes[i] = int(index)
+		value = value[n:]
+	}
+	return indexes, value, nil
+}
+
+func (d *Decoder) messageDescriptor(schemaId int32, indexes []int) (*desc.MessageDescriptor, error) {
+	var file *desc.FileDescriptor
+	if fileI, ok := d.DescriptorCache.Load(schemaId); ok {
+		file, _ = fileI.(*desc.FileDescriptor)
+	}
+
+	if file == nil {
+		schema, err := d.SchemaRegistry.GetSchemaWithReferences(schemaId)
+		if err != nil {
+			return nil, err
+		}
+		fileName := fmt.Sprintf("schema-%d.proto", schemaId)
+		files := map[string]string{fileName: schema.Schema}
+		for name, reference := range schema.References {
+			files[name] = reference
+		}
+		parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(files)}
+		parsed, err := parser.ParseFiles(fileName)
+		if err != nil {
+			return nil, err
+		}
+		file = parsed[0]
+
+		d.DescriptorCache.Store(schemaId, file)
+	}
+
+	var md *desc.MessageDescriptor
+	messageTypes := file.GetMessageTypes()
+	for _, index := range indexes {
+		if index < 0 || index >= len(messageTypes) {
+			return nil, fmt.Errorf("message index %d not found in schema %d", index, schemaId)
+		}
+		md = messageTypes[index]
+		messageTypes = md.GetNestedMessageTypes()
+	}
+	return md, nil
+}
@@ -0,0 +1,135 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/inloco/kafka-elasticsearch-injector/src/schema_registry"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/stretchr/testify/assert"
+)
+
+const commonProto = `syntax = "proto3";
+package common;
+message Tenant {
+  string id = 1;
+}`
+
+const eventProto = `syntax = "proto3";
+package events;
+import "common.proto";
+message Other {
+  int32 value = 1;
+}
+message Purchase {
+  string id = 1;
+  common.Tenant tenant = 2;
+  message Item {
+    string sku = 1;
+    double price = 2;
+  }
+  repeated Item items = 3;
+}`
+
+func newProtobufRegistry(t *testing.T) (*schema_registry.SchemaRegistry, func()) {
+	return newTestRegistry(t, map[string]interface{}{
+		"/schemas/ids/7": map[string]interface{}{
+			"schema":     eventProto,
+			"schemaType": "PROTOBUF",
+			"references": []map[string]interface{}{{"name": "common.proto", "subject": "common", "version": 1}},
+		},
+		"/subjects/common/versions/1": map[string]interface{}{"schema": commonProto, "schemaType": "PROTOBUF"},
+	})
+}
+
+func encodePurchase(t *testing.T, indexes []byte) []byte {
+	parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(map[string]string{
+		"event.proto":  eventProto,
+		"common.proto": commonProto,
+	})}
+	files, err := parser.ParseFiles("event.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	purchaseType := files[0].FindMessage("events.Purchase")
+	purchase := dynamic.NewMessage(purchaseType)
+	tenant := dynamic.NewMessage(purchaseType.FindFieldByName("tenant").GetMessageType())
+	tenant.SetFieldByName("id", "acme")
+	item := dynamic.NewMessage(purchaseType.GetNestedMessageTypes()[0])
+	item.SetFieldByName("sku", "sku-1")
+	item.SetFieldByName("price", 9.5)
+	purchase.SetFieldByName("id", "p-1")
+	purchase.SetFieldByName("tenant", tenant)
+	purchase.AddRepeatedFieldByName("items", item)
+	payload, err := purchase.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := []byte{0, 0, 0, 0, 7} // magic byte and schema id
+	value = append(value, indexes...)
+	return append(value, payload...)
+}
+
+func TestDecoder_ProtobufMessageToRecord(t *testing.T) {
+	registry, closeRegistry := newProtobufRegistry(t)
+	defer closeRegistry()
+	d := &Decoder{SchemaRegistry: registry}
+	timestamp := time.Now()
+
+	// message indexes [1] (Purchase), zig-zag encoded: count 1 -> 2, index 1 -> 2
+	record, err := d.ProtobufMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     encodePurchase(t, []byte{2, 2}),
+		Topic:     "test",
+		Partition: 1,
+		Offset:    54,
+		Timestamp: timestamp,
+	}, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "p-1", record.Json["id"])
+		assert.Equal(t, map[string]interface{}{"id": "acme"}, record.Json["tenant"])
+		assert.Equal(t, []interface{}{map[string]interface{}{"sku": "sku-1", "price": 9.5}}, record.Json["items"])
+		assert.Equal(t, makeTimestamp(timestamp), record.Json[kafkaTimestampKey])
+	}
+}
+
+func TestDecoder_ProtobufMessageToRecord_WrongMessageIndex(t *testing.T) {
+	registry, closeRegistry := newProtobufRegistry(t)
+	defer closeRegistry()
+	d := &Decoder{SchemaRegistry: registry}
+
+	// message indexes [5], there are only two messages in the schema
+	_, err := d.ProtobufMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     encodePurchase(t, []byte{2, 10}),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	assert.Error(t, err)
+}
+
+func TestDecoder_ProtobufMessageToRecord_InvalidWireFormat(t *testing.T) {
+	d := &Decoder{}
+	_, err := d.ProtobufMessageToRecord(context.Background(), &sarama.ConsumerMessage{
+		Value:     []byte(`{"id": "p-1"}`),
+		Topic:     "test",
+		Timestamp: time.Now(),
+	}, false)
+	assert.Equal(t, errInvalidProtobufWireFormat, err)
+}
+
+func TestReadMessageIndexes(t *testing.T) {
+	indexes, rest, err := readMessageIndexes([]byte{0, 42})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{0}, indexes)
+		assert.Equal(t, []byte{42}, rest)
+	}
+
+	indexes, rest, err = readMessageIndexes([]byte{4, 2, 6, 42})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{1, 3}, indexes)
+		assert.Equal(t, []byte{42}, rest)
+	}
+}
@@ -0,0 +1,30 @@
+package kafka
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/inloco/kafka-elasticsearch-injector/src/schema_registry"
+)
+
+// newTestRegistry serves a schema registry answering each path of responses
+// with its JSON encoding, and 404 for any other path. The returned function
+// stops the server.
+func newTestRegistry(t *testing.T, responses map[string]interface{}) (*schema_registry.SchemaRegistry, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	registry, err := schema_registry.NewSchemaRegistry(server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return registry, server.Close
+}
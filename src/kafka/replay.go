@@ -0,0 +1,278 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log/level"
+)
+
+// replayMarker ignores processed messages, replays never commit offsets so the
+// consumer group resumes from where it was once it is started again.
+type replayMarker struct{}
+
+func (replayMarker) MarkMessage(*sarama.ConsumerMessage, string) {}
+
+type partitionRange struct {
+	topic     string
+	partition int32
+	start     int64 // first offset to consume
+	end       int64 // offset after the last one to consume
+}
+
+// Replay consumes the subscribed topics from the first record produced at or
+// after from, regardless of the consumer group offsets. It stops before the
+// first record produced at or after until or, when until is zero, at the end
+// of the partitions as of when the replay started, or earlier at the
+// endOffsets of partitions, keyed by topic and partition. Replay returns once
+// every record in that range is sent to the endpoint. When interrupted, it
+// returns ErrShutdownTimeout if the buffered messages weren't flushed in time.
+func (k *kafka) Replay(from, until time.Time, endOffsets map[string]map[int32]int64, signals chan os.Signal, notifications chan<- Notification) error {
+	client, err := sarama.NewClient(k.brokers, k.config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	k.timestampTypes = newTimestampTypes(client, k.consumer.Logger)
+
+	subscription := newTopicSubscription(k.consumer.Topics, k.consumer.TopicsPattern)
+	if err := subscription.resolve(client); err != nil {
+		return err
+	}
+	k.timestampTypes.resolve(subscription.current())
+	ranges, err := replayRanges(client, subscription.current(), from, until, endOffsets)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+	partitionConsumers := make([]sarama.PartitionConsumer, len(ranges))
+	for i, r := range ranges {
+		partitionConsumers[i], err = consumer.ConsumePartition(r.topic, r.partition, r.start)
+		if err != nil {
+			return err
+		}
+		level.Info(k.consumer.Logger).Log(
+			"message", "Replaying partition",
+			"topic", r.topic,
+			"partition", r.partition,
+			"start", r.start,
+			"end", r.end,
+		)
+	}
+
+	go k.updateOffsets()
+	handler := &consumerGroupHandler{
+		kafka:         k,
+		notifications: notifications,
+	}
+	handler.startWorkers(replayMarker{})
+
+	stop := make(chan struct{})
+	var partitions sync.WaitGroup
+	for i, r := range ranges {
+		partitions.Add(1)
+		go func(pc sarama.PartitionConsumer, r partitionRange) {
+			defer partitions.Done()
+			handler.replayPartition(pc, r, stop)
+		}(partitionConsumers[i], r)
+	}
+
+	replayed := make(chan struct{})
+	go func() {
+		partitions.Wait()
+		handler.stopWorkers()
+		close(replayed)
+	}()
+	select {
+	case <-replayed:
+		level.Info(k.consumer.Logger).Log("message", "Replay finished")
+		return nil
+	case <-signals:
+	}
+
+	level.Info(k.consumer.Logger).Log("message", "Replay interrupted, flushing buffered messages")
+	close(stop)
+	select {
+	case <-replayed:
+	case <-time.After(k.consumer.ShutdownTimeout):
+		level.Warn(k.consumer.Logger).Log(
+			"message", "Shutdown timeout reached before flushing all buffered messages",
+			"timeout", k.consumer.ShutdownTimeout,
+		)
+		return ErrShutdownTimeout
+	}
+	return errors.New("replay interrupted")
+}
+
+// replayPartition sends the messages of r to the workers, until the message
+// before the end of r is sent. Not every offset holds a message: transaction
+// markers and compacted records are never delivered. So when no message
+// arrives for a while and the broker has offsets up to the end of r, the
+// remaining offsets are assumed to hold no records and the partition is done,
+// logging the last offset replayed.
+func (h *consumerGroupHandler) replayPartition(pc sarama.PartitionConsumer, r partitionRange, stop <-chan struct{}) {
+	defer func() {
+		if err := pc.Close(); err != nil {
+			level.Error(h.consumer.Logger).Log("message", "Failed to close partition consumer", "err", err.Error())
+		}
+	}()
+	last := r.start - 1
+	// send returns whether the partition is done
+	send := func(msg *sarama.ConsumerMessage) bool {
+		if msg.Offset >= r.end {
+			return true
+		}
+		select {
+		case h.consumerCh <- msg:
+		case <-stop:
+			return true
+		}
+		last = msg.Offset
+		return last >= r.end-1
+	}
+	idleTimeout := h.replayIdleTimeout()
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	resetIdle := func() {
+		if !idle.Stop() {
+			<-idle.C
+		}
+		idle.Reset(idleTimeout)
+	}
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if send(msg) {
+				return
+			}
+			resetIdle()
+		case <-idle.C:
+			// messages fetched while the timer fired, e.g. after a long pause,
+			// are not a sign of an idle partition
+			select {
+			case msg := <-pc.Messages():
+				if send(msg) {
+					return
+				}
+				idle.Reset(idleTimeout)
+				continue
+			default:
+			}
+			if pc.HighWaterMarkOffset() >= r.end {
+				level.Warn(h.consumer.Logger).Log(
+					"message", "No more records arrived before the end of the partition, assuming the remaining offsets hold no records",
+					"topic", r.topic,
+					"partition", r.partition,
+					"lastOffset", last,
+					"end", r.end,
+				)
+				return
+			}
+			idle.Reset(idleTimeout)
+		case err := <-pc.Errors():
+			level.Error(h.consumer.Logger).Log(
+				"message", "Failed to consume message",
+				"err", err.Error(),
+			)
+			// fetches are failing, e.g. during a leader change, the partition
+			// isn't idle
+			resetIdle()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// replayIdleTimeout is how long partitions are waited for messages before
+// being considered done, several fetches and fetch retries long.
+func (h *consumerGroupHandler) replayIdleTimeout() time.Duration {
+	timeout := 10 * h.config.Consumer.MaxWaitTime
+	if retries := 10 * h.config.Consumer.Retry.Backoff; retries > timeout {
+		timeout = retries
+	}
+	if timeout > 100*time.Millisecond {
+		return timeout
+	}
+	return 100 * time.Millisecond
+}
+
+// ParseReplayEndOffsets parses a comma separated list of
+// <topic>:<partition>:<offset> end offsets.
+func ParseReplayEndOffsets(offsets string) (map[string]map[int32]int64, error) {
+	endOffsets := make(map[string]map[int32]int64)
+	for _, entry := range strings.Split(offsets, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid end offset %q, expected <topic>:<partition>:<offset>", entry)
+		}
+		partition, err := strconv.ParseInt(parts[1], 10, 32)
+		if err != nil || partition < 0 {
+			return nil, fmt.Errorf("invalid partition in end offset %q", entry)
+		}
+		offset, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset in end offset %q", entry)
+		}
+		if _, exists := endOffsets[parts[0]]; !exists {
+			endOffsets[parts[0]] = make(map[int32]int64)
+		}
+		endOffsets[parts[0]][int32(partition)] = offset
+	}
+	return endOffsets, nil
+}
+
+// replayRanges resolves, for every partition of topics, the offsets of the
+// records produced between from and until, ending at endOffsets if earlier.
+func replayRanges(client sarama.Client, topics []string, from, until time.Time, endOffsets map[string]map[int32]int64) ([]partitionRange, error) {
+	var ranges []partitionRange
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			start, err := client.GetOffset(topic, partition, makeTimestamp(from))
+			if err != nil {
+				return nil, err
+			}
+			if start == sarama.OffsetNewest { // no record produced after from
+				continue
+			}
+			end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, err
+			}
+			if !until.IsZero() {
+				untilOffset, err := client.GetOffset(topic, partition, makeTimestamp(until))
+				if err != nil {
+					return nil, err
+				}
+				if untilOffset != sarama.OffsetNewest && untilOffset < end {
+					end = untilOffset
+				}
+			}
+			if endOffset, ok := endOffsets[topic][partition]; ok && endOffset < end {
+				end = endOffset
+			}
+			if start < end {
+				ranges = append(ranges, partitionRange{topic, partition, start, end})
+			}
+		}
+	}
+	return ranges, nil
+}
@@ -0,0 +1,202 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayRanges(t *testing.T) {
+	from := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(time.Hour)
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("my-topic", 0, broker.BrokerID()).
+			SetLeader("my-topic", 1, broker.BrokerID()).
+			SetLeader("my-topic", 2, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			// records in range, stops at until
+			SetOffset("my-topic", 0, makeTimestamp(from), 10).
+			SetOffset("my-topic", 0, makeTimestamp(until), 20).
+			SetOffset("my-topic", 0, sarama.OffsetNewest, 30).
+			// no record after until, stops at the end of the partition
+			SetOffset("my-topic", 1, makeTimestamp(from), 5).
+			SetOffset("my-topic", 1, makeTimestamp(until), sarama.OffsetNewest).
+			SetOffset("my-topic", 1, sarama.OffsetNewest, 8).
+			// no record after from
+			SetOffset("my-topic", 2, makeTimestamp(from), sarama.OffsetNewest).
+			SetOffset("my-topic", 2, sarama.OffsetNewest, 3),
+	})
+	client, err := sarama.NewClient([]string{broker.Addr()}, sarama.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ranges, err := replayRanges(client, []string{"my-topic"}, from, until, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []partitionRange{
+			{"my-topic", 0, 10, 20},
+			{"my-topic", 1, 5, 8},
+		}, ranges)
+	}
+
+	ranges, err = replayRanges(client, []string{"my-topic"}, from, time.Time{}, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []partitionRange{
+			{"my-topic", 0, 10, 30},
+			{"my-topic", 1, 5, 8},
+		}, ranges)
+	}
+
+	// end offsets only apply when earlier than until
+	endOffsets := map[string]map[int32]int64{"my-topic": {0: 25, 1: 6}}
+	ranges, err = replayRanges(client, []string{"my-topic"}, from, until, endOffsets)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []partitionRange{
+			{"my-topic", 0, 10, 20},
+			{"my-topic", 1, 5, 6},
+		}, ranges)
+	}
+}
+
+func TestParseReplayEndOffsets(t *testing.T) {
+	endOffsets, err := ParseReplayEndOffsets("orders:0:1500, orders:1:1720,my.topic:2:0,")
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]map[int32]int64{
+			"orders":   {0: 1500, 1: 1720},
+			"my.topic": {2: 0},
+		}, endOffsets)
+	}
+
+	for _, offsets := range []string{"orders:0", "orders:a:1", "orders:0:-1", ":0:1", "orders:0:1:2"} {
+		_, err := ParseReplayEndOffsets(offsets)
+		assert.Error(t, err, offsets)
+	}
+}
+
+// fakePartitionConsumer delivers messages, with a fixed high water mark.
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages      chan *sarama.ConsumerMessage
+	errors        chan *sarama.ConsumerError
+	highWaterMark int64
+}
+
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.messages
+}
+
+func (pc *fakePartitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	return pc.errors
+}
+
+func (pc *fakePartitionConsumer) HighWaterMarkOffset() int64 {
+	return pc.highWaterMark
+}
+
+func (pc *fakePartitionConsumer) Close() error {
+	return nil
+}
+
+func TestReplayPartition_EndsWithoutMessageAtEnd(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Consumer.MaxWaitTime = time.Millisecond
+	config.Consumer.Retry.Backoff = time.Millisecond
+	h := &consumerGroupHandler{
+		kafka:      &kafka{config: config, consumer: Consumer{Logger: log.NewNopLogger()}},
+		consumerCh: make(chan *sarama.ConsumerMessage, 10),
+	}
+	// offset 3 is a transaction marker, which is never delivered
+	pc := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage, 3), highWaterMark: 4}
+	for offset := int64(0); offset < 3; offset++ {
+		pc.messages <- &sarama.ConsumerMessage{Topic: "my-topic", Offset: offset}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.replayPartition(pc, partitionRange{"my-topic", 0, 0, 4}, make(chan struct{}))
+		close(done)
+	}()
+	select {
+	case <-done:
+		assert.Len(t, h.consumerCh, 3)
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay didn't end")
+	}
+}
+
+func TestReplayPartition_WaitsForHighWaterMark(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Consumer.MaxWaitTime = time.Millisecond
+	config.Consumer.Retry.Backoff = time.Millisecond
+	h := &consumerGroupHandler{
+		kafka:      &kafka{config: config, consumer: Consumer{Logger: log.NewNopLogger()}},
+		consumerCh: make(chan *sarama.ConsumerMessage, 10),
+	}
+	// the broker hasn't reported the offsets up to the end yet
+	pc := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage), highWaterMark: 2}
+
+	done := make(chan struct{})
+	go func() {
+		h.replayPartition(pc, partitionRange{"my-topic", 0, 0, 4}, make(chan struct{}))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("replay ended before the end of the partition")
+	case <-time.After(500 * time.Millisecond):
+	}
+	pc.messages <- &sarama.ConsumerMessage{Topic: "my-topic", Offset: 3}
+	<-done
+	assert.Len(t, h.consumerCh, 1)
+}
+
+func TestReplayPartition_WaitsWhileFetchesFail(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Consumer.MaxWaitTime = time.Millisecond
+	config.Consumer.Retry.Backoff = time.Millisecond
+	h := &consumerGroupHandler{
+		kafka:      &kafka{config: config, consumer: Consumer{Logger: log.NewNopLogger()}},
+		consumerCh: make(chan *sarama.ConsumerMessage, 10),
+	}
+	pc := &fakePartitionConsumer{
+		messages:      make(chan *sarama.ConsumerMessage),
+		errors:        make(chan *sarama.ConsumerError),
+		highWaterMark: 4,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.replayPartition(pc, partitionRange{"my-topic", 0, 0, 4}, make(chan struct{}))
+		close(done)
+	}()
+	// the leader of the partition changes, fetches fail for longer than the
+	// idle timeout
+	for i := 0; i < 10; i++ {
+		pc.errors <- &sarama.ConsumerError{Topic: "my-topic", Err: sarama.ErrNotLeaderForPartition}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("replay ended while fetches were failing")
+	default:
+	}
+	pc.messages <- &sarama.ConsumerMessage{Topic: "my-topic", Offset: 3}
+	<-done
+	assert.Len(t, h.consumerCh, 1)
+}
+
+func TestReplayIdleTimeout(t *testing.T) {
+	config := sarama.NewConfig()
+	h := &consumerGroupHandler{kafka: &kafka{config: config}}
+	// the default 2s retry backoff outlasts ten 250ms fetches
+	assert.Equal(t, 20*time.Second, h.replayIdleTimeout())
+}
@@ -0,0 +1,225 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// NewSaramaConfig builds the sarama configuration shared by every Kafka client
+// of the app (the consumer and the dead letter producer), including SASL, TLS
+// and consumer tuning settings. Empty settings keep sarama's defaults, invalid
+// ones are reported as errors so they fail at startup.
+func NewSaramaConfig(c *Config) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_3_0_0
+	if c.Version != "" {
+		version, err := sarama.ParseKafkaVersion(c.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka version: %w", err)
+		}
+		config.Version = version
+	}
+	if c.ClientID != "" {
+		config.ClientID = c.ClientID
+	}
+
+	if err := configureConsumer(config, c); err != nil {
+		return nil, err
+	}
+	if err := configureSASL(config, c); err != nil {
+		return nil, err
+	}
+	if err := configureTLS(config, c); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func configureConsumer(config *sarama.Config, c *Config) error {
+	switch strings.ToLower(c.InitialOffset) {
+	case "":
+	case "oldest":
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case "newest":
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		return fmt.Errorf("invalid initial offset %s, should be oldest or newest", c.InitialOffset)
+	}
+
+	switch strings.ToLower(c.IsolationLevel) {
+	case "":
+	case "read_uncommitted":
+		config.Consumer.IsolationLevel = sarama.ReadUncommitted
+	case "read_committed":
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	default:
+		return fmt.Errorf("invalid isolation level %s, should be read_uncommitted or read_committed", c.IsolationLevel)
+	}
+
+	if c.FetchMinBytes != "" {
+		min, err := strconv.ParseInt(c.FetchMinBytes, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid fetch min bytes: %w", err)
+		}
+		config.Consumer.Fetch.Min = int32(min)
+	}
+	if c.FetchMaxBytes != "" {
+		max, err := strconv.ParseInt(c.FetchMaxBytes, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid fetch max bytes: %w", err)
+		}
+		config.Consumer.Fetch.Max = int32(max)
+		if max > 0 && config.Consumer.Fetch.Default > int32(max) {
+			config.Consumer.Fetch.Default = int32(max)
+		}
+	}
+
+	if c.SessionTimeout != "" {
+		timeout, err := time.ParseDuration(c.SessionTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid session timeout: %w", err)
+		}
+		config.Consumer.Group.Session.Timeout = timeout
+	}
+	if c.HeartbeatInterval != "" {
+		interval, err := time.ParseDuration(c.HeartbeatInterval)
+		if err != nil {
+			return fmt.Errorf("invalid heartbeat interval: %w", err)
+		}
+		config.Consumer.Group.Heartbeat.Interval = interval
+	}
+	if config.Consumer.Group.Heartbeat.Interval >= config.Consumer.Group.Session.Timeout {
+		return fmt.Errorf(
+			"heartbeat interval (%s) must be lower than session timeout (%s)",
+			config.Consumer.Group.Heartbeat.Interval,
+			config.Consumer.Group.Session.Timeout,
+		)
+	}
+	return nil
+}
+
+func configureSASL(config *sarama.Config, c *Config) error {
+	if c.SASLMechanism == "" {
+		return nil
+	}
+	config.Net.SASL.Enable = true
+	// brokers older than 1.0 only support the first handshake version
+	if config.Version.IsAtLeast(sarama.V1_0_0_0) {
+		config.Net.SASL.Version = sarama.SASLHandshakeV1
+	} else {
+		config.Net.SASL.Version = sarama.SASLHandshakeV0
+	}
+	config.Net.SASL.User = c.SASLUsername
+	config.Net.SASL.Password = c.SASLPassword
+
+	mechanism := strings.ToUpper(c.SASLMechanism)
+	if (mechanism == sarama.SASLTypeSCRAMSHA256 || mechanism == sarama.SASLTypeSCRAMSHA512) && !config.Version.IsAtLeast(sarama.V1_0_0_0) {
+		// sarama always authenticates with SCRAM over the second handshake version
+		return fmt.Errorf("SASL mechanism %s requires kafka 1.0.0 or later", c.SASLMechanism)
+	}
+
+	switch mechanism {
+	case sarama.SASLTypePlaintext:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha256.New}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha512.New}
+		}
+	default:
+		return fmt.Errorf("unsupported SASL mechanism %s", c.SASLMechanism)
+	}
+	return nil
+}
+
+func configureTLS(config *sarama.Config, c *Config) error {
+	enabled, err := parseOptionalBool(c.TLSEnabled)
+	if err != nil {
+		return fmt.Errorf("invalid TLS enabled flag: %w", err)
+	}
+	if !enabled {
+		if c.TLSCAFile != "" || c.TLSCertFile != "" || c.TLSKeyFile != "" {
+			return errors.New("TLS files are set, but TLS is not enabled")
+		}
+		return nil
+	}
+	insecureSkipVerify, err := parseOptionalBool(c.TLSInsecureSkipVerify)
+	if err != nil {
+		return fmt.Errorf("invalid TLS insecure skip verify flag: %w", err)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if c.TLSCAFile != "" {
+		ca, err := ioutil.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read TLS CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates found in TLS CA file %s", c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("could not load TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = tlsConfig
+	return nil
+}
+
+func parseOptionalBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// scramClient implements sarama.SCRAMClient
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.Client = client
+	c.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
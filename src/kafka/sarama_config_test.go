@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSaramaConfig_Defaults(t *testing.T) {
+	config, err := NewSaramaConfig(&Config{})
+	if assert.NoError(t, err) {
+		assert.False(t, config.Net.SASL.Enable)
+		assert.False(t, config.Net.TLS.Enable)
+		assert.Equal(t, sarama.V2_3_0_0, config.Version)
+	}
+}
+
+func TestNewSaramaConfig_SCRAM(t *testing.T) {
+	config, err := NewSaramaConfig(&Config{
+		SASLMechanism: "scram-sha-512",
+		SASLUsername:  "user",
+		SASLPassword:  "secret",
+		TLSEnabled:    "true",
+	})
+	if assert.NoError(t, err) {
+		assert.True(t, config.Net.SASL.Enable)
+		assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+		assert.Equal(t, "user", config.Net.SASL.User)
+		assert.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc())
+		assert.True(t, config.Net.TLS.Enable)
+	}
+}
+
+func TestNewSaramaConfig_SASLHandshakeVersion(t *testing.T) {
+	config, err := NewSaramaConfig(&Config{Version: "0.10.2.0", SASLMechanism: "PLAIN", SASLUsername: "user", SASLPassword: "secret"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, sarama.SASLHandshakeV0, config.Net.SASL.Version)
+	}
+	config, err = NewSaramaConfig(&Config{Version: "1.0.0", SASLMechanism: "PLAIN", SASLUsername: "user", SASLPassword: "secret"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, sarama.SASLHandshakeV1, config.Net.SASL.Version)
+	}
+	_, err = NewSaramaConfig(&Config{Version: "0.10.2.0", SASLMechanism: "SCRAM-SHA-256", SASLUsername: "user", SASLPassword: "secret"})
+	assert.Error(t, err)
+}
+
+func TestNewSaramaConfig_UnsupportedSASLMechanism(t *testing.T) {
+	_, err := NewSaramaConfig(&Config{SASLMechanism: "GSSAPI"})
+	assert.Error(t, err)
+}
+
+func TestNewSaramaConfig_MissingCAFile(t *testing.T) {
+	_, err := NewSaramaConfig(&Config{TLSEnabled: "true", TLSCAFile: "/nonexistent/ca.pem"})
+	assert.Error(t, err)
+}
+
+func TestNewSaramaConfig_TLSFilesWithoutTLS(t *testing.T) {
+	for _, config := range []*Config{
+		{TLSCAFile: "ca.pem"},
+		{TLSCertFile: "client.pem", TLSKeyFile: "client.key"},
+		{TLSEnabled: "false", TLSCAFile: "ca.pem"},
+	} {
+		_, err := NewSaramaConfig(config)
+		assert.Error(t, err, "%+v", config)
+	}
+}
+
+func TestNewSaramaConfig_ConsumerTuning(t *testing.T) {
+	config, err := NewSaramaConfig(&Config{
+		Version:           "2.1.0",
+		ClientID:          "es-injector",
+		InitialOffset:     "oldest",
+		FetchMinBytes:     "1024",
+		FetchMaxBytes:     "524288",
+		SessionTimeout:    "30s",
+		HeartbeatInterval: "5s",
+		IsolationLevel:    "read_committed",
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, sarama.V2_1_0_0, config.Version)
+		assert.Equal(t, "es-injector", config.ClientID)
+		assert.Equal(t, sarama.OffsetOldest, config.Consumer.Offsets.Initial)
+		assert.Equal(t, int32(1024), config.Consumer.Fetch.Min)
+		assert.Equal(t, int32(524288), config.Consumer.Fetch.Max)
+		assert.Equal(t, int32(524288), config.Consumer.Fetch.Default)
+		assert.Equal(t, 30*time.Second, config.Consumer.Group.Session.Timeout)
+		assert.Equal(t, 5*time.Second, config.Consumer.Group.Heartbeat.Interval)
+		assert.Equal(t, sarama.ReadCommitted, config.Consumer.IsolationLevel)
+	}
+}
+
+func TestNewSaramaConfig_InvalidConsumerTuning(t *testing.T) {
+	for _, c := range []*Config{
+		{Version: "not-a-version"},
+		{InitialOffset: "latest"},
+		{IsolationLevel: "serializable"},
+		{FetchMinBytes: "a lot"},
+		{SessionTimeout: "5s", HeartbeatInterval: "10s"},
+	} {
+		_, err := NewSaramaConfig(c)
+		assert.Error(t, err)
+	}
+}
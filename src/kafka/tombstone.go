@@ -0,0 +1,34 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	"github.com/inloco/kafka-elasticsearch-injector/src/models"
+)
+
+// deleteOnTombstone wraps decode so that tombstones, messages with a key
+// and no value, become deletions of the document identified by their key.
+// Scalar keys are the document ID, struct keys are kept as the record JSON
+// so the ID can be picked from one of their fields by the codec.
+func (d *Decoder) deleteOnTombstone(decode DecodeMessageFunc, decodeKey func([]byte) (interface{}, error)) DecodeMessageFunc {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage, includeKey bool) (*models.Record, error) {
+		if msg.Value != nil || msg.Key == nil {
+			return decode(ctx, msg, includeKey)
+		}
+		key, err := decodeKey(msg.Key)
+		if err != nil {
+			return nil, err
+		}
+		var id string
+		value, isStruct := key.(map[string]interface{})
+		if !isStruct {
+			value = make(map[string]interface{})
+			id = formatKey(key)
+		}
+		record := d.newRecord(msg, value)
+		record.ID = id
+		record.Delete = true
+		return record, nil
+	}
+}
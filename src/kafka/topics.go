@@ -0,0 +1,118 @@
+package kafka
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// topicSubscription holds the topics the consumer group subscribes to. When
+// a pattern is given, the topics are periodically resolved from the cluster
+// metadata and changed is signaled whenever they differ from the current ones,
+// so the group session can be restarted with the new subscription.
+type topicSubscription struct {
+	pattern *regexp.Regexp
+	lock    sync.RWMutex
+	topics  []string
+	changed chan struct{}
+}
+
+func newTopicSubscription(topics []string, pattern *regexp.Regexp) *topicSubscription {
+	if pattern != nil {
+		topics = nil // resolved by the first refresh
+	}
+	return &topicSubscription{
+		pattern: pattern,
+		topics:  topics,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (s *topicSubscription) current() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.topics
+}
+
+// resolve resolves the topics matching the pattern before the first group
+// session, without signaling a change that would end that session right after
+// it joins the group.
+func (s *topicSubscription) resolve(client sarama.Client) error {
+	_, err := s.update(client, false)
+	return err
+}
+
+// refresh resolves the topics matching the pattern and reports whether they
+// changed. It is a no-op for subscriptions to a fixed list of topics.
+func (s *topicSubscription) refresh(client sarama.Client) (bool, error) {
+	return s.update(client, true)
+}
+
+func (s *topicSubscription) update(client sarama.Client, notify bool) (bool, error) {
+	if s.pattern == nil {
+		return false, nil
+	}
+	if err := client.RefreshMetadata(); err != nil {
+		return false, err
+	}
+	available, err := client.Topics()
+	if err != nil {
+		return false, err
+	}
+	var topics []string
+	for _, topic := range available {
+		if strings.HasPrefix(topic, "__") { // internal topics, such as __consumer_offsets
+			continue
+		}
+		if s.pattern.MatchString(topic) {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if reflect.DeepEqual(topics, s.topics) {
+		return false, nil
+	}
+	s.topics = topics
+	if !notify {
+		return true, nil
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default: // a change is already pending
+	}
+	return true, nil
+}
+
+func (s *topicSubscription) watch(client sarama.Client, interval time.Duration, logger log.Logger) {
+	if s.pattern == nil {
+		return
+	}
+	for range time.Tick(interval) {
+		changed, err := s.refresh(client)
+		if err != nil {
+			level.Error(logger).Log(
+				"message", "Failed to refresh topics matching pattern",
+				"pattern", s.pattern.String(),
+				"err", err.Error(),
+			)
+			continue
+		}
+		if changed {
+			level.Info(logger).Log(
+				"message", "Topics matching pattern changed",
+				"pattern", s.pattern.String(),
+				"topics", strings.Join(s.current(), ","),
+			)
+		}
+	}
+}
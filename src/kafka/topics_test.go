@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMetadataClient(t *testing.T, topics ...string) (sarama.Client, *sarama.MockBroker) {
+	broker := sarama.NewMockBroker(t, 1)
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	for _, topic := range topics {
+		metadata = metadata.SetLeader(topic, 0, broker.BrokerID())
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{"MetadataRequest": metadata})
+
+	client, err := sarama.NewClient([]string{broker.Addr()}, sarama.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, broker
+}
+
+func TestTopicSubscription_Refresh_Pattern(t *testing.T) {
+	client, broker := newMetadataClient(t, "events.tenant-b", "events.tenant-a", "other", "__consumer_offsets")
+	defer broker.Close()
+	defer client.Close()
+
+	subscription := newTopicSubscription(nil, regexp.MustCompile(`^events\.tenant-.*$`))
+	changed, err := subscription.refresh(client)
+	if assert.NoError(t, err) {
+		assert.True(t, changed)
+		assert.Equal(t, []string{"events.tenant-a", "events.tenant-b"}, subscription.current())
+	}
+
+	changed, err = subscription.refresh(client)
+	if assert.NoError(t, err) {
+		assert.False(t, changed)
+	}
+}
+
+func TestTopicSubscription_Refresh_FixedTopics(t *testing.T) {
+	subscription := newTopicSubscription([]string{"my-topic"}, nil)
+	changed, err := subscription.refresh(nil)
+	if assert.NoError(t, err) {
+		assert.False(t, changed)
+		assert.Equal(t, []string{"my-topic"}, subscription.current())
+	}
+}
+
+// fakeConsumerGroup records the sessions consume runs, which last until their
+// context is done.
+type fakeConsumerGroup struct {
+	sarama.ConsumerGroup
+	sessions chan []string
+	ended    chan struct{}
+	closed   bool
+}
+
+func (g *fakeConsumerGroup) Close() error {
+	g.closed = true
+	return nil
+}
+
+func (g *fakeConsumerGroup) Consume(ctx context.Context, topics []string, _ sarama.ConsumerGroupHandler) error {
+	g.sessions <- topics
+	<-ctx.Done()
+	g.ended <- struct{}{}
+	return nil
+}
+
+func TestConsume_InitialTopicsDontEndFirstSession(t *testing.T) {
+	client, broker := newMetadataClient(t, "events.tenant-a")
+	defer broker.Close()
+	defer client.Close()
+
+	subscription := newTopicSubscription(nil, regexp.MustCompile(`^events\..*$`))
+	if err := subscription.resolve(client); !assert.NoError(t, err) {
+		return
+	}
+
+	k := &kafka{consumer: Consumer{Logger: log.NewNopLogger()}}
+	group := &fakeConsumerGroup{sessions: make(chan []string, 2), ended: make(chan struct{}, 2)}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		k.consume(ctx, group, subscription, nil)
+		close(stopped)
+	}()
+
+	assert.Equal(t, []string{"events.tenant-a"}, <-group.sessions)
+	select {
+	case <-group.ended:
+		t.Fatal("first session ended without a topic change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// later changes end the session, so the next one joins with the new topics
+	subscription.topics = nil
+	changed, err := subscription.refresh(client)
+	if assert.NoError(t, err) && assert.True(t, changed) {
+		<-group.ended
+		assert.Equal(t, []string{"events.tenant-a"}, <-group.sessions)
+	}
+
+	cancel()
+	<-group.ended
+	<-stopped
+}
@@ -20,13 +20,16 @@ type metrics struct {
 	bufferFullGauge          *kitprometheus.Gauge
 	elasticsearchRetries     *kitprometheus.Counter
 	elasticsearchConflicts   *kitprometheus.Counter
+	elasticsearchOutdated    *kitprometheus.Counter
 	elasticsearchBadRequest  *kitprometheus.Counter
+	deadLettered             *kitprometheus.Counter
+	recordsFiltered          *kitprometheus.Counter
 	lock                     sync.RWMutex
 	topicPartitionToOffset   map[string]map[int32]int64
 }
 
-func (m *metrics) IncrementRecordsConsumed(count int) {
-	m.recordsConsumed.Add(float64(count))
+func (m *metrics) IncrementRecordsConsumed(topic string, count int) {
+	m.recordsConsumed.With("topic", topic).Add(float64(count))
 }
 
 func (m *metrics) RecordEndpointLatency(latency float64) {
@@ -72,27 +75,42 @@ func (m *metrics) BufferFull(full bool) {
 	m.bufferFullGauge.Set(val)
 }
 
-func (m *metrics) ElasticsearchRetries(count int) {
-	m.elasticsearchRetries.Add(float64(count))
+func (m *metrics) ElasticsearchRetries(topic string, count int) {
+	m.elasticsearchRetries.With("topic", topic).Add(float64(count))
 }
 
-func (m *metrics) ElasticsearchConflicts(count int) {
-	m.elasticsearchConflicts.Add(float64(count))
+func (m *metrics) ElasticsearchConflicts(topic string, count int) {
+	m.elasticsearchConflicts.With("topic", topic).Add(float64(count))
 }
 
-func (m *metrics) ElasticsearchBadRequests(count int) {
-	m.elasticsearchBadRequest.Add(float64(count))
+func (m *metrics) ElasticsearchOutdated(topic string, count int) {
+	m.elasticsearchOutdated.With("topic", topic).Add(float64(count))
+}
+
+func (m *metrics) ElasticsearchBadRequests(topic string, count int) {
+	m.elasticsearchBadRequest.With("topic", topic).Add(float64(count))
+}
+
+func (m *metrics) IncrementDeadLettered(topic string, stage string, count int) {
+	m.deadLettered.With("topic", topic, "stage", stage).Add(float64(count))
+}
+
+func (m *metrics) IncrementRecordsFiltered(topic string, count int) {
+	m.recordsFiltered.With("topic", topic).Add(float64(count))
 }
 
 type MetricsPublisher interface {
 	PublishOffsetMetrics(highWaterMarks map[string]map[int32]int64)
 	UpdateOffset(topic string, partition int32, delay int64)
-	IncrementRecordsConsumed(count int)
+	IncrementRecordsConsumed(topic string, count int)
 	RecordEndpointLatency(latency float64)
 	BufferFull(full bool)
-	ElasticsearchRetries(count int)
-	ElasticsearchConflicts(count int)
-	ElasticsearchBadRequests(cont int)
+	ElasticsearchRetries(topic string, count int)
+	ElasticsearchConflicts(topic string, count int)
+	ElasticsearchOutdated(topic string, count int)
+	ElasticsearchBadRequests(topic string, count int)
+	IncrementDeadLettered(topic string, stage string, count int)
+	IncrementRecordsFiltered(topic string, count int)
 }
 
 func NewMetricsPublisher() MetricsPublisher {
@@ -100,7 +118,7 @@ func NewMetricsPublisher() MetricsPublisher {
 	recordsConsumed := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 		Name: "kafka_consumer_records_consumed_successfully",
 		Help: "Number of records consumed successfully",
-	}, []string{})
+	}, []string{"topic"})
 	partitionDelay := kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
 		Name: "kafka_consumer_partition_delay",
 		Help: "Kafka consumer partition delay",
@@ -116,15 +134,27 @@ func NewMetricsPublisher() MetricsPublisher {
 	elasticsearchRetriesCounter := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 		Name: "elasticsearch_events_retryed",
 		Help: "number of events that needed to be retryed sending to Elasticsearch",
-	}, []string{})
+	}, []string{"topic"})
 	elasticsearchConflictsCounter := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 		Name: "elasticsearch_document_already_exists",
 		Help: "number of events that tried to be inserted on elasticsearch but alredy existed",
-	}, []string{})
+	}, []string{"topic"})
+	elasticsearchOutdatedCounter := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "elasticsearch_document_outdated",
+		Help: "number of events skipped because elasticsearch has a newer version of their document",
+	}, []string{"topic"})
 	elasticsearchBadRequestCounter := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 		Name: "elasticsearch_bad_request",
 		Help: "the number of malformed events",
-	}, []string{})
+	}, []string{"topic"})
+	deadLetteredCounter := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "kafka_consumer_records_dead_lettered",
+		Help: "Number of records sent to the dead letter topic, by failure stage",
+	}, []string{"topic", "stage"})
+	recordsFilteredCounter := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "kafka_consumer_records_filtered",
+		Help: "Number of records skipped for not matching the consumer filter",
+	}, []string{"topic"})
 	return &metrics{
 		logger:                   logger,
 		partitionDelay:           partitionDelay,
@@ -134,7 +164,10 @@ func NewMetricsPublisher() MetricsPublisher {
 		lock:                     sync.RWMutex{},
 		elasticsearchRetries:     elasticsearchRetriesCounter,
 		elasticsearchConflicts:   elasticsearchConflictsCounter,
+		elasticsearchOutdated:    elasticsearchOutdatedCounter,
 		elasticsearchBadRequest:  elasticsearchBadRequestCounter,
+		deadLettered:             deadLetteredCounter,
+		recordsFiltered:          recordsFilteredCounter,
 		topicPartitionToOffset:   make(map[string]map[int32]int64),
 	}
 }
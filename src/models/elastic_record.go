@@ -1,8 +1,19 @@
 package models
 
+// Bulk operations of Elasticsearch records.
+const (
+	OpTypeCreate = "create"
+	OpTypeIndex  = "index"
+	OpTypeUpdate = "update"
+	OpTypeDelete = "delete"
+)
+
 type ElasticRecord struct {
-	Index string
-	Type  string
-	ID    string
-	Json  map[string]interface{}
+	Index   string
+	Type    string
+	ID      string
+	OpType  string
+	Version *int64 // external version, nil if none
+	Json    map[string]interface{}
+	Source  *Record // record this document was encoded from, if any
 }
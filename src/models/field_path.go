@@ -0,0 +1,328 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldPath is a parsed path to a record field, see parseFieldPath.
+type FieldPath struct {
+	path     string
+	segments []pathSegment
+}
+
+func ParseFieldPath(path string) (FieldPath, error) {
+	segments, err := parseFieldPath(path)
+	if err != nil {
+		return FieldPath{}, err
+	}
+	return FieldPath{path: path, segments: segments}, nil
+}
+
+func (p FieldPath) String() string {
+	return p.path
+}
+
+// Overlaps reports whether p and other are the same field, or one of them is
+// nested in the other.
+func (p FieldPath) Overlaps(other FieldPath) bool {
+	n := len(p.segments)
+	if len(other.segments) < n {
+		n = len(other.segments)
+	}
+	for i := 0; i < n; i++ {
+		if p.segments[i].key != other.segments[i].key || p.segments[i].index != other.segments[i].index {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the value at the path in doc.
+func (p FieldPath) Lookup(doc map[string]interface{}) (interface{}, bool) {
+	return lookupSegments(doc, p.segments)
+}
+
+// Remove removes the value at the path from doc. Nested objects and arrays
+// are copied before being changed, instead of changed in place.
+func (p FieldPath) Remove(doc map[string]interface{}) bool {
+	return removeSegments(doc, p.segments)
+}
+
+// Set sets the value at the path in doc, creating the missing objects along
+// the path. Nested objects and arrays are copied before being changed, as in
+// Remove. It fails when a value along the path is neither an object nor an
+// array.
+func (p FieldPath) Set(doc map[string]interface{}, value interface{}) bool {
+	return setSegments(doc, p.segments, value)
+}
+
+// pathSegment is an object key or an array index of a field path.
+type pathSegment struct {
+	key    string
+	index  int  // array index, -1 for keys
+	quoted bool // whether the key was quoted, quoted keys are never split on dots
+}
+
+// parseFieldPath parses dotted field paths, e.g. "tenant.id", with optional
+// JSONPath-style notation: a "$." root, quoted keys and array indexes, e.g.
+// "$.items[0]['user.name']".
+func parseFieldPath(path string) ([]pathSegment, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "$" || rest == "" {
+		return nil, fmt.Errorf("empty field path %q", path)
+	}
+	var segments []pathSegment
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in field path %q", path)
+			}
+			segment, err := parseBracket(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid field path %q: %w", path, err)
+			}
+			segments = append(segments, segment)
+			rest = strings.TrimPrefix(rest[end+1:], ".")
+			continue
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("empty key in field path %q", path)
+		}
+		segments = append(segments, pathSegment{key: rest[:end], index: -1})
+		rest = rest[end:]
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return nil, fmt.Errorf("empty key in field path %q", path)
+			}
+		}
+	}
+	return segments, nil
+}
+
+func parseBracket(bracket string) (pathSegment, error) {
+	if len(bracket) >= 2 && (bracket[0] == '\'' || bracket[0] == '"') && bracket[len(bracket)-1] == bracket[0] {
+		return pathSegment{key: bracket[1 : len(bracket)-1], index: -1, quoted: true}, nil
+	}
+	index, err := strconv.Atoi(bracket)
+	if err != nil || index < 0 {
+		return pathSegment{}, fmt.Errorf("invalid index [%s]", bracket)
+	}
+	return pathSegment{index: index}, nil
+}
+
+// keyCandidates returns the keys the leading segments can refer to, longest
+// first, so keys containing dots themselves, e.g. "geo.point", are found too.
+func keyCandidates(segments []pathSegment) []string {
+	if segments[0].index >= 0 {
+		return nil
+	}
+	if segments[0].quoted {
+		return []string{segments[0].key}
+	}
+	n := 0
+	for n < len(segments) && segments[n].index < 0 && !segments[n].quoted {
+		n++
+	}
+	candidates := make([]string, n)
+	for k := n; k >= 1; k-- {
+		keys := make([]string, k)
+		for i := range keys {
+			keys[i] = segments[i].key
+		}
+		candidates[n-k] = strings.Join(keys, ".")
+	}
+	return candidates
+}
+
+// candidateLength is the number of segments joined in a key candidate.
+func candidateLength(candidate string, segments []pathSegment) int {
+	if segments[0].quoted {
+		return 1
+	}
+	return strings.Count(candidate, ".") + 1
+}
+
+func lookupSegments(value interface{}, segments []pathSegment) (interface{}, bool) {
+	if len(segments) == 0 {
+		return value, true
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range keyCandidates(segments) {
+			if child, ok := v[key]; ok {
+				if found, ok := lookupSegments(child, segments[candidateLength(key, segments):]); ok {
+					return found, true
+				}
+			}
+		}
+	case []interface{}:
+		if index := segments[0].index; index >= 0 && index < len(v) {
+			return lookupSegments(v[index], segments[1:])
+		}
+	}
+	return nil, false
+}
+
+// removeSegments removes the field at segments from values. Nested objects
+// and arrays are copied before being changed, so values shared with the
+// original record are left untouched.
+func removeSegments(values map[string]interface{}, segments []pathSegment) bool {
+	for _, key := range keyCandidates(segments) {
+		child, ok := values[key]
+		if !ok {
+			continue
+		}
+		rest := segments[candidateLength(key, segments):]
+		if len(rest) == 0 {
+			delete(values, key)
+			return true
+		}
+		if copied, ok := removeFromValue(child, rest); ok {
+			values[key] = copied
+			return true
+		}
+	}
+	return false
+}
+
+func removeFromValue(value interface{}, segments []pathSegment) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			copied[key] = child
+		}
+		if removeSegments(copied, segments) {
+			return copied, true
+		}
+	case []interface{}:
+		index := segments[0].index
+		if index < 0 || index >= len(v) {
+			return nil, false
+		}
+		copied := make([]interface{}, len(v))
+		copy(copied, v)
+		if len(segments) == 1 {
+			return append(copied[:index], copied[index+1:]...), true
+		}
+		if removed, ok := removeFromValue(v[index], segments[1:]); ok {
+			copied[index] = removed
+			return copied, true
+		}
+	}
+	return nil, false
+}
+
+func setSegments(values map[string]interface{}, segments []pathSegment, value interface{}) bool {
+	if segments[0].index >= 0 {
+		return false
+	}
+	if len(segments) == 1 {
+		values[segments[0].key] = value
+		return true
+	}
+	for _, key := range keyCandidates(segments) {
+		child, ok := values[key]
+		if !ok {
+			continue
+		}
+		rest := segments[candidateLength(key, segments):]
+		if len(rest) == 0 {
+			values[key] = value
+			return true
+		}
+		if updated, ok := setInValue(child, rest, value); ok {
+			values[key] = updated
+			return true
+		}
+	}
+	if _, exists := values[segments[0].key]; exists {
+		return false
+	}
+	nested := make(map[string]interface{})
+	if !setSegments(nested, segments[1:], value) {
+		return false
+	}
+	values[segments[0].key] = nested
+	return true
+}
+
+func setInValue(target interface{}, segments []pathSegment, value interface{}) (interface{}, bool) {
+	switch v := target.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v)+1)
+		for key, child := range v {
+			copied[key] = child
+		}
+		if setSegments(copied, segments, value) {
+			return copied, true
+		}
+	case []interface{}:
+		index := segments[0].index
+		if index < 0 || index >= len(v) {
+			return nil, false
+		}
+		copied := make([]interface{}, len(v))
+		copy(copied, v)
+		if len(segments) == 1 {
+			copied[index] = value
+			return copied, true
+		}
+		if updated, ok := setInValue(v[index], segments[1:], value); ok {
+			copied[index] = updated
+			return copied, true
+		}
+	}
+	return nil, false
+}
+
+// FormatScalar formats the scalar values records can hold as strings. Floats
+// are never formatted in scientific notation, so integral JSON numbers keep
+// their digits, e.g. "10000000".
+func FormatScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.FormatInt(int64(v), 10), true
+	case int8:
+		return strconv.FormatInt(int64(v), 10), true
+	case int16:
+		return strconv.FormatInt(int64(v), 10), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), true
+	}
+	return "", false
+}
@@ -1,10 +1,26 @@
 package models
 
 import (
-	"strconv"
+	"strings"
 	"time"
 
 	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// MetadataPrefix prefixes the fields looked up in the record metadata
+// instead of its JSON, e.g. "@metadata.topic" or "@metadata.headers.tenant".
+const MetadataPrefix = "@metadata."
+
+// Keys of the Kafka message metadata.
+const (
+	MetadataTopic         = "topic"
+	MetadataPartition     = "partition"
+	MetadataOffset        = "offset"
+	MetadataTimestamp     = "timestamp"
+	MetadataTimestampType = "timestamp_type"
+	MetadataHeaders       = "headers"
 )
 
 type Record struct {
@@ -13,6 +29,11 @@ type Record struct {
 	Offset    int64
 	Timestamp time.Time
 	Json      map[string]interface{}
+	Message   *sarama.ConsumerMessage // original Kafka message, used for dead lettering
+	Metadata  map[string]interface{}  // Kafka message metadata, nil unless enabled
+	ID        string                  // document ID set when decoding, e.g. from a change event key
+	Delete    bool                    // whether the document with ID is to be deleted
+	Version   *int64                  // external document version, nil if none
 }
 
 func (r *Record) FormatTimestampDay() string {
@@ -28,29 +49,49 @@ func (r *Record) GetId() string {
 }
 
 func (r *Record) GetValueForField(field string) (string, error) {
-	if value, ok := r.Json[field]; ok {
-		switch castedValue := value.(type) {
-		case string:
-			return castedValue, nil
-		case int32:
-			return strconv.FormatInt(int64(castedValue), 10), nil
-		default:
-			return "", fmt.Errorf("Value from colum %s is not parseable to string", field)
+	if value, ok := r.LookupField(field); ok {
+		if formatted, ok := FormatScalar(value); ok {
+			return formatted, nil
 		}
+		return "", fmt.Errorf("Value from colum %s is not parseable to string", field)
 	}
 	return "", fmt.Errorf("could not get value from column %s", field)
 }
 
-func (r *Record) FilteredFieldsJSON(blacklistedFields []string) map[string]interface{} {
-	blacklistedFieldsMap := make(map[string]bool)
-	for _, blacklistedField := range blacklistedFields {
-		blacklistedFieldsMap[blacklistedField] = true
+// LookupField returns the value of a record field. Fields of nested objects
+// are looked up by their path, see parseFieldPath.
+func (r *Record) LookupField(field string) (interface{}, bool) {
+	values := r.Json
+	path := field
+	if strings.HasPrefix(field, MetadataPrefix) {
+		values = r.Metadata
+		path = strings.TrimPrefix(field, MetadataPrefix)
+		if header := strings.TrimPrefix(path, MetadataHeaders+"."); header != path {
+			headers, _ := r.Metadata[MetadataHeaders].(map[string]interface{})
+			value, ok := headers[header]
+			return value, ok
+		}
 	}
-	filteredRecord := make(map[string]interface{})
+	segments, err := parseFieldPath(path)
+	if err != nil {
+		return nil, false
+	}
+	return lookupSegments(values, segments)
+}
+
+// FilteredFieldsJSON returns the record JSON without the blacklisted fields,
+// which can be nested field paths as in LookupField.
+func (r *Record) FilteredFieldsJSON(blacklistedFields []string) map[string]interface{} {
+	filteredRecord := make(map[string]interface{}, len(r.Json))
 	for key, value := range r.Json {
-		if !blacklistedFieldsMap[key] {
-			filteredRecord[key] = value
+		filteredRecord[key] = value
+	}
+	for _, blacklistedField := range blacklistedFields {
+		segments, err := parseFieldPath(blacklistedField)
+		if err != nil {
+			continue
 		}
+		removeSegments(filteredRecord, segments)
 	}
 	return filteredRecord
 }
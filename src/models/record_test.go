@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"math/rand"
 	"testing"
 	"time"
@@ -53,3 +54,148 @@ func createDummyRecord(fieldName string, fieldValue string) *Record {
 		Json:      map[string]interface{}{fieldName: fieldValue},
 	}
 }
+
+func TestRecord_GetValueForField_Metadata(t *testing.T) {
+	record := createDummyRecord(existentFieldName, existentFieldValue)
+	record.Metadata = map[string]interface{}{
+		MetadataTopic:  record.Topic,
+		MetadataOffset: int64(42),
+		MetadataHeaders: map[string]interface{}{
+			"tenant": "acme",
+		},
+	}
+
+	value, err := record.GetValueForField("@metadata.topic")
+	if assert.NoError(t, err) {
+		assert.Equal(t, record.Topic, value)
+	}
+	value, err = record.GetValueForField("@metadata.offset")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "42", value)
+	}
+	value, err = record.GetValueForField("@metadata.headers.tenant")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "acme", value)
+	}
+	_, err = record.GetValueForField("@metadata.headers.missing")
+	assert.Error(t, err)
+}
+
+func TestRecord_GetValueForField_MetadataDisabled(t *testing.T) {
+	record := createDummyRecord(existentFieldName, existentFieldValue)
+
+	_, err := record.GetValueForField("@metadata.headers.tenant")
+	assert.Error(t, err)
+}
+
+func TestRecord_LookupField_NestedField(t *testing.T) {
+	record := &Record{Json: map[string]interface{}{
+		"tenant":    map[string]interface{}{"id": "tenant-1"},
+		"geo.point": map[string]interface{}{"lat": 1.5},
+	}}
+
+	value, ok := record.LookupField("tenant.id")
+	if assert.True(t, ok) {
+		assert.Equal(t, "tenant-1", value)
+	}
+	value, ok = record.LookupField("geo.point.lat")
+	if assert.True(t, ok) {
+		assert.Equal(t, 1.5, value)
+	}
+	_, ok = record.LookupField("tenant.name")
+	assert.False(t, ok)
+}
+
+func TestRecord_LookupField_JSONPath(t *testing.T) {
+	record := &Record{Json: map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"user.name": "alice"},
+		},
+		// a single key object, not an Avro union: unions are unwrapped when
+		// Avro records are decoded
+		"labels": map[string]interface{}{"Team": map[string]interface{}{"name": "payments"}},
+	}}
+
+	value, ok := record.LookupField("$.items[0]['user.name']")
+	if assert.True(t, ok) {
+		assert.Equal(t, "alice", value)
+	}
+	value, ok = record.LookupField("labels.Team.name")
+	if assert.True(t, ok) {
+		assert.Equal(t, "payments", value)
+	}
+	_, ok = record.LookupField("labels.name")
+	assert.False(t, ok)
+	for _, invalid := range []string{"$", "items[", "items[x]", "items.", "items[1]"} {
+		_, ok = record.LookupField(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestRecord_GetValueForField_Scalars(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"value", "value"},
+		{true, "true"},
+		{int32(42), "42"},
+		{int64(1) << 40, "1099511627776"},
+		{float64(42), "42"},
+		{1.5, "1.5"},
+		{float32(0.25), "0.25"},
+		{json.Number("12345678901234567890"), "12345678901234567890"},
+		{time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), "2021-01-02T03:04:05Z"},
+	}
+	for _, tt := range tests {
+		record := &Record{Json: map[string]interface{}{"nested": map[string]interface{}{"field": tt.value}}}
+
+		value, err := record.GetValueForField("nested.field")
+		if assert.NoError(t, err) {
+			assert.Equal(t, tt.expected, value)
+		}
+	}
+
+	record := &Record{Json: map[string]interface{}{"object": map[string]interface{}{"a": 1}, "null": nil}}
+	_, err := record.GetValueForField("object")
+	assert.Error(t, err)
+	_, err = record.GetValueForField("null")
+	assert.Error(t, err)
+}
+
+func TestFieldPath_Overlaps(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		overlaps bool
+	}{
+		{"user.email", "user.email", true},
+		{"user", "user.email", true},
+		{"$.user['email']", "user", true},
+		{"items[0].email", "items", true},
+		{"user.email", "user.phone", false},
+		{"items[0]", "items[1]", false},
+		{"username", "user", false},
+	}
+	for _, tt := range tests {
+		a, _ := ParseFieldPath(tt.a)
+		b, _ := ParseFieldPath(tt.b)
+		assert.Equal(t, tt.overlaps, a.Overlaps(b), "%s %s", tt.a, tt.b)
+		assert.Equal(t, tt.overlaps, b.Overlaps(a), "%s %s", tt.b, tt.a)
+	}
+}
+
+func TestRecord_FilteredFieldsJSON_NestedFields(t *testing.T) {
+	user := map[string]interface{}{"name": "alice", "password": "secret"}
+	items := []interface{}{map[string]interface{}{"token": "t", "id": 1}}
+	record := &Record{Json: map[string]interface{}{"user": user, "items": items, "id": 1}}
+
+	filteredJson := record.FilteredFieldsJSON([]string{"user.password", "items[0].token", "inexistent.field", ""})
+	assert.Equal(t, map[string]interface{}{
+		"user":  map[string]interface{}{"name": "alice"},
+		"items": []interface{}{map[string]interface{}{"id": 1}},
+		"id":    1,
+	}, filteredJson)
+	// fields are removed from copies of the nested objects and arrays
+	assert.Equal(t, map[string]interface{}{"name": "alice", "password": "secret"}, user)
+	assert.Equal(t, []interface{}{map[string]interface{}{"token": "t", "id": 1}}, items)
+}
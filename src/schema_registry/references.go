@@ -0,0 +1,91 @@
+package schema_registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// SchemaReference points to a schema imported by another one, such as a
+// .proto file imported by a Protobuf schema.
+type SchemaReference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// ResolvedSchema is a schema along with every schema it references, directly
+// or indirectly, keyed by reference name.
+type ResolvedSchema struct {
+	Schema     string
+	References map[string]string
+}
+
+type registeredSchema struct {
+	Schema     string            `json:"schema"`
+	References []SchemaReference `json:"references"`
+}
+
+// GetSchemaWithReferences returns the schema registered with id and its
+// references. The schema registry client does not support references, so
+// they're fetched straight from the REST API.
+func (sr *SchemaRegistry) GetSchemaWithReferences(id int32) (*ResolvedSchema, error) {
+	if schema, exists := sr.resolvedSchemas.Load(id); exists {
+		if resolved, ok := schema.(*ResolvedSchema); ok {
+			return resolved, nil
+		}
+	}
+
+	var registered registeredSchema
+	if err := sr.get(fmt.Sprintf("schemas/ids/%d", id), &registered); err != nil {
+		return nil, err
+	}
+	resolved := &ResolvedSchema{
+		Schema:     registered.Schema,
+		References: make(map[string]string),
+	}
+	if err := sr.resolveReferences(registered.References, resolved.References); err != nil {
+		return nil, err
+	}
+	sr.resolvedSchemas.Store(id, resolved)
+	return resolved, nil
+}
+
+func (sr *SchemaRegistry) resolveReferences(references []SchemaReference, resolved map[string]string) error {
+	for _, reference := range references {
+		if _, done := resolved[reference.Name]; done {
+			continue
+		}
+		var registered registeredSchema
+		urlPath := fmt.Sprintf("subjects/%s/versions/%d", url.PathEscape(reference.Subject), reference.Version)
+		if err := sr.get(urlPath, &registered); err != nil {
+			return err
+		}
+		resolved[reference.Name] = registered.Schema
+		if err := sr.resolveReferences(registered.References, resolved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sr *SchemaRegistry) get(urlPath string, out interface{}) error {
+	u := *sr.url
+	u.Path = path.Join(u.Path, urlPath)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/vnd.schemaregistry.v1+json, application/vnd.schemaregistry+json, application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("schema registry returned status %d for %s", resp.StatusCode, urlPath)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
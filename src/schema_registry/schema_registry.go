@@ -1,6 +1,7 @@
 package schema_registry
 
 import (
+	"net/url"
 	"sync"
 
 	"github.com/datamountaineer/schema-registry"
@@ -9,8 +10,10 @@ import (
 const INVALID_SCHEMA = "Invalid Schema"
 
 type SchemaRegistry struct {
-	Client  schemaregistry.Client
-	schemas *sync.Map
+	Client          schemaregistry.Client
+	schemas         *sync.Map
+	resolvedSchemas *sync.Map
+	url             *url.URL
 }
 
 func (sr *SchemaRegistry) GetSchema(id int32) (string, error) {
@@ -26,14 +29,30 @@ func (sr *SchemaRegistry) GetSchema(id int32) (string, error) {
 	return schema, err
 }
 
-func NewSchemaRegistry(url string) (*SchemaRegistry, error) {
-	client, err := schemaregistry.NewClient(url)
+// GetLatestSchema returns the id and the latest version of the schema
+// registered under subject.
+func (sr *SchemaRegistry) GetLatestSchema(subject string) (int32, string, error) {
+	schema, err := sr.Client.GetLatestSchema(subject)
+	if err != nil {
+		return 0, "", err
+	}
+	return int32(schema.Id), schema.Schema, nil
+}
+
+func NewSchemaRegistry(registryURL string) (*SchemaRegistry, error) {
+	client, err := schemaregistry.NewClient(registryURL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(registryURL)
 	if err != nil {
 		return nil, err
 	}
 	return &SchemaRegistry{
-		Client:  client,
-		schemas: &sync.Map{},
+		Client:          client,
+		schemas:         &sync.Map{},
+		resolvedSchemas: &sync.Map{},
+		url:             u,
 	}, nil
 }
 
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"github.com/Shopify/sarama"
 	"github.com/go-kit/kit/log/level"
+	"github.com/inloco/kafka-elasticsearch-injector/src/kafka"
 	"github.com/inloco/kafka-elasticsearch-injector/src/kafka/fixtures"
 	"github.com/inloco/kafka-elasticsearch-injector/src/logger_builder"
 	"github.com/inloco/kafka-elasticsearch-injector/src/schema_registry"
@@ -18,12 +18,32 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	config := sarama.NewConfig()
+	version := os.Getenv("KAFKA_VERSION")
+	if version == "" {
+		version = "0.10.0.0" // This version is the same as in production
+	}
+	config, err := kafka.NewSaramaConfig(&kafka.Config{
+		Version:               version,
+		SASLMechanism:         os.Getenv("KAFKA_SASL_MECHANISM"),
+		SASLUsername:          os.Getenv("KAFKA_SASL_USERNAME"),
+		SASLPassword:          os.Getenv("KAFKA_SASL_PASSWORD"),
+		TLSEnabled:            os.Getenv("KAFKA_TLS_ENABLED"),
+		TLSCAFile:             os.Getenv("KAFKA_TLS_CA_FILE"),
+		TLSCertFile:           os.Getenv("KAFKA_TLS_CERT_FILE"),
+		TLSKeyFile:            os.Getenv("KAFKA_TLS_KEY_FILE"),
+		TLSInsecureSkipVerify: os.Getenv("KAFKA_TLS_INSECURE_SKIP_VERIFY"),
+	})
+	if err != nil {
+		panic(err)
+	}
 	config.Producer.Return.Successes = true
 	config.Producer.MaxMessageBytes = 20 * 1024 * 1024 // 20mb
 	config.Producer.Flush.Frequency = 1 * time.Millisecond
-	config.Version = sarama.V0_10_0_0 // This version is the same as in production
-	producer, err := fixtures.NewProducer(os.Getenv("KAFKA_ADDRESS"), config, registry)
+	brokers, err := kafka.ParseBrokers(os.Getenv("KAFKA_ADDRESS"))
+	if err != nil {
+		panic(err)
+	}
+	producer, err := fixtures.NewProducer(brokers, config, registry)
 	if err != nil {
 		panic(err)
 	}